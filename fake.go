@@ -0,0 +1,21 @@
+//go:build fake
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/edulink/edulinktest"
+)
+
+// startFakeEdulink starts an in-process fake EduLink, for demos, and returns
+// its resolver with the school code, username and password of its account
+func startFakeEdulink() (edulink.SchoolResolver, string, string, string) {
+	fixtures := edulinktest.DefaultFixtures()
+	fakeServer := edulinktest.NewServer(fixtures)
+	fmt.Println("Using fake EduLink at", fakeServer.Endpoint())
+
+	account := fixtures.Accounts[0]
+	return fakeServer.Resolver(), fixtures.SchoolCode, account.Username, account.Password
+}
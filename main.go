@@ -1,95 +1,738 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/eu-evops/edulink/pkg/cache"
 	"github.com/eu-evops/edulink/pkg/cache/common"
 	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/mailer"
+	"github.com/eu-evops/edulink/pkg/scheduler"
 	"github.com/eu-evops/edulink/pkg/web"
 	"github.com/eu-evops/edulink/pkg/worker"
 )
 
+const (
+	DefaultEdulinkSchoolCode   = "roundwoodpark"
+	DefaultEdulinkRecordingDir = "fixtures/edulink"
+	DefaultLocalCacheSnapshot  = "edulink-cache.json"
+)
+
 var (
-	EdulinkUsername string
-	EdulinkPassword string
-	MailgunApiKey   string
+	EdulinkUsername   string
+	EdulinkPassword   string
+	EdulinkSchoolCode string
+	MailgunApiKey     string
+
+	appCache        *cache.Cache
+	memoryOnlyCache bool
+	// defaultSnapshot is set when LOCAL_CACHE_SNAPSHOT is not, the default
+	// path is lost with the container
+	defaultSnapshot bool
+	edulinkAccounts []*worker.Account
+	mailerOptions   *mailer.MailerOptions
 
-	appCache *cache.Cache
+	scheduledJobs         []scheduler.Job
+	scheduledJobOptions   []*worker.RunOptions
+	schedulerLocation     = time.Local
+	schedulerDrainTimeout time.Duration
+
+	quietHours  *worker.QuietHours
+	calendar    *worker.Calendar
+	holidayMode worker.HolidayMode
 )
 
-func init() {
+// setupCache sets appCache up from the environment, it is all --migrate-cache needs
+func setupCache() {
+	cacheOptions, err := redisCacheOptions()
+	if err != nil {
+		fmt.Println("Invalid Redis configuration:", err)
+		os.Exit(1)
+	}
+
+	// DISK_CACHE_PATH keeps everything in a database file instead of Redis.
+	// Without either, everything is kept in memory and saved to
+	// LOCAL_CACHE_SNAPSHOT after every account the worker ran for, which
+	// sending email needs set explicitly. LOCAL_CACHE_SNAPSHOT=none only keeps
+	// it in memory, which is only good for dry runs.
+	if path := os.Getenv("DISK_CACHE_PATH"); path != "" {
+		cacheOptions.CacheType = common.Disk
+		cacheOptions.DiskPath = path
+		fmt.Println("Using a disk cache at", path)
+	} else if !redisConfigured() {
+		cacheOptions.CacheType = common.Local
+		cacheOptions.LocalSnapshotPath = os.Getenv("LOCAL_CACHE_SNAPSHOT")
+		switch cacheOptions.LocalSnapshotPath {
+		case "":
+			cacheOptions.LocalSnapshotPath = DefaultLocalCacheSnapshot
+			defaultSnapshot = true
+		case "none":
+			cacheOptions.LocalSnapshotPath = ""
+			memoryOnlyCache = true
+		}
+
+		if value := os.Getenv("LOCAL_CACHE_MAX_ENTRIES"); value != "" {
+			maxEntries, err := strconv.Atoi(value)
+			if err != nil || maxEntries < 1 {
+				fmt.Println("LOCAL_CACHE_MAX_ENTRIES must be a positive number")
+				os.Exit(1)
+			}
+			cacheOptions.LocalMaxEntries = maxEntries
+		}
+
+		if memoryOnlyCache {
+			fmt.Println("Redis is not configured, already seen items are forgotten on exit")
+		} else {
+			fmt.Println("Redis is not configured, using a local cache saved to", cacheOptions.LocalSnapshotPath)
+		}
+	}
+
+	appCache = cache.New(cacheOptions)
+
+	if err := appCache.Initialise(); err != nil {
+		fmt.Println("Could not initialise the cache:", err)
+		os.Exit(1)
+	}
+
+	// a namespace set on a cache in use starts out without any already seen state
+	if appCache.Prefix() != "" {
+		inside, _ := appCache.Scan(context.Background(), "set:")
+		outside, _ := appCache.Unscoped().Scan(context.Background(), "set:")
+		if len(inside) == 0 && len(outside) > 0 {
+			fmt.Println("CACHE_NAMESPACE holds no already seen state but the cache outside it does, see --migrate-cache to-namespace")
+		}
+	}
+}
+
+// setup reads the EduLink accounts, mailer and schedule from the environment
+func setup() {
 	EdulinkUsername = os.Getenv("EDULINK_USERNAME")
 	EdulinkPassword = os.Getenv("EDULINK_PASSWORD")
 	MailgunApiKey = os.Getenv("MAILGUN_API_KEY")
 
-	if EdulinkUsername == "" || EdulinkPassword == "" {
-		fmt.Println("Please set EDULINK_USERNAME and EDULINK_PASSWORD environment variables")
+	EdulinkSchoolCode = os.Getenv("EDULINK_SCHOOL_CODE")
+	if EdulinkSchoolCode == "" {
+		EdulinkSchoolCode = DefaultEdulinkSchoolCode
+	}
+
+	var schoolResolver edulink.SchoolResolver = edulink.NewProvisioningResolver(&edulink.ProvisioningResolverOptions{
+		Endpoint: os.Getenv("EDULINK_PROVISIONING_ENDPOINT"),
+	})
+
+	// EDULINK_ENDPOINT and EDULINK_ESTABLISHMENT_ID bypass the provisioning lookup for EDULINK_SCHOOL_CODE
+	var staticResolver edulink.StaticResolver
+	if endpoint := os.Getenv("EDULINK_ENDPOINT"); endpoint != "" {
+		establishmentID, err := strconv.Atoi(os.Getenv("EDULINK_ESTABLISHMENT_ID"))
+		if err != nil {
+			fmt.Println("EDULINK_ESTABLISHMENT_ID must be a number when EDULINK_ENDPOINT is set")
+			os.Exit(1)
+		}
+
+		staticResolver = edulink.StaticResolver{
+			strings.ToLower(EdulinkSchoolCode): {Endpoint: endpoint, EstablishmentID: establishmentID},
+		}
+	}
+
+	// EDULINK_ACCOUNTS_FILE lists several accounts, otherwise a single account
+	// is configured through EDULINK_USERNAME, EDULINK_PASSWORD and EMAIL_RECIPIENTS
+	var accountConfigs []worker.AccountConfig
+	if path := os.Getenv("EDULINK_ACCOUNTS_FILE"); path != "" {
+		configs, err := worker.LoadAccountConfigs(path)
+		if err != nil {
+			fmt.Println("Could not load EDULINK_ACCOUNTS_FILE:", err)
+			os.Exit(1)
+		}
+		accountConfigs = configs
+	}
+
+	// EDULINK_FAKE runs against an in-process fake EduLink, for demos, in a
+	// build with -tags fake
+	fakeEdulink := os.Getenv("EDULINK_FAKE") == "true"
+	if fakeEdulink {
+		schoolResolver, EdulinkSchoolCode, EdulinkUsername, EdulinkPassword = startFakeEdulink()
+		staticResolver = nil
+		accountConfigs = nil
+	}
+
+	if accountConfigs == nil {
+		if EdulinkUsername == "" || EdulinkPassword == "" {
+			fmt.Println("Please set EDULINK_USERNAME and EDULINK_PASSWORD or EDULINK_ACCOUNTS_FILE environment variables")
+			os.Exit(1)
+		}
+
+		recipients := []string{}
+		if value := os.Getenv("EMAIL_RECIPIENTS"); value != "" {
+			recipients = strings.Split(value, ",")
+		}
+
+		// EMAIL_ROUTES_FILE routes some children's reports to more recipients
+		var routes []worker.Route
+		if path := os.Getenv("EMAIL_ROUTES_FILE"); path != "" {
+			loaded, err := worker.LoadRoutes(path)
+			if err != nil {
+				fmt.Println("Could not load EMAIL_ROUTES_FILE:", err)
+				os.Exit(1)
+			}
+			routes = loaded
+		}
+
+		// no name, so the account keeps the already seen state it had before accounts were configurable
+		accountConfigs = []worker.AccountConfig{{
+			SchoolCode: EdulinkSchoolCode,
+			Username:   EdulinkUsername,
+			Password:   EdulinkPassword,
+			Recipients: recipients,
+			Routes:     routes,
+		}}
+	}
+
+	mailDomain := os.Getenv("MAIL_DOMAIN")
+	if mailDomain == "" {
+		mailDomain = mailer.DefaultDomain
+	}
+
+	mailerOptions = &mailer.MailerOptions{
+		Domain:  mailDomain,
+		From:    os.Getenv("MAIL_FROM"),
+		ReplyTo: os.Getenv("MAIL_REPLY_TO"),
+	}
+
+	// MAIL_SUBJECT is a Go template over the report, e.g. {{ .Child.Forename }}: {{ signed .NetPoints }} points
+	if value := os.Getenv("MAIL_SUBJECT"); value != "" {
+		subject, err := mailer.ParseSubject(value)
+		if err != nil {
+			fmt.Println("Invalid MAIL_SUBJECT:", err)
+			os.Exit(1)
+		}
+		mailerOptions.Subject = subject
+	}
+
+	// MAIL_TRANSPORT picks how reports are sent: mailgun (default) or smtp
+	switch os.Getenv("MAIL_TRANSPORT") {
+	case "", "mailgun":
+		// only needed when sending, --dry-run works without it
+		if MailgunApiKey == "" {
+			break
+		}
+
+		mailerOptions.Transport = mailer.NewMailgunTransport(&mailer.MailgunTransportOptions{
+			Domain: mailDomain,
+			ApiKey: MailgunApiKey,
+		})
+	case "smtp":
+		port := 0
+		if value := os.Getenv("SMTP_PORT"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				fmt.Println("SMTP_PORT must be a number")
+				os.Exit(1)
+			}
+			port = parsed
+		}
+
+		transport, err := mailer.NewSMTPTransport(&mailer.SMTPTransportOptions{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Security: mailer.SMTPSecurity(os.Getenv("SMTP_SECURITY")),
+			Auth:     mailer.SMTPAuth(os.Getenv("SMTP_AUTH")),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		})
+		if err != nil {
+			fmt.Println("Invalid SMTP configuration:", err)
+			os.Exit(1)
+		}
+		mailerOptions.Transport = transport
+	default:
+		fmt.Println("MAIL_TRANSPORT must be mailgun or smtp")
 		os.Exit(1)
 	}
 
-	if MailgunApiKey == "" {
-		fmt.Println("Please set MAILGUN_API_KEY environment variable")
+	// WORKER_SCHEDULE keeps the process running and reports on the accounts
+	// whenever one of its ;-separated cron expressions fires, e.g. "30 16 * * mon-fri".
+	// WORKER_DIGEST_SCHEDULE sends everything from the past week, reported or not.
+	if value := os.Getenv("WORKER_TIMEZONE"); value != "" {
+		location, err := time.LoadLocation(value)
+		if err != nil {
+			fmt.Println("Invalid WORKER_TIMEZONE:", err)
+			os.Exit(1)
+		}
+		schedulerLocation = location
+	}
+
+	if value := os.Getenv("WORKER_DRAIN_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			fmt.Println("Invalid WORKER_DRAIN_TIMEOUT:", err)
+			os.Exit(1)
+		}
+		schedulerDrainTimeout = timeout
+	}
+
+	schedules := []struct {
+		env     string
+		name    string
+		options *worker.RunOptions
+	}{
+		{"WORKER_SCHEDULE", "report", nil},
+		{"WORKER_DIGEST_SCHEDULE", "weekly digest", &worker.RunOptions{MaximumAge: 7 * edulink.Day, ReportPrevious: true}},
+	}
+	for _, schedule := range schedules {
+		for _, expression := range strings.Split(os.Getenv(schedule.env), ";") {
+			if strings.TrimSpace(expression) == "" {
+				continue
+			}
+
+			parsed, err := scheduler.Parse(expression)
+			if err != nil {
+				fmt.Printf("Invalid %s: %s\n", schedule.env, err)
+				os.Exit(1)
+			}
+
+			scheduledJobs = append(scheduledJobs, scheduler.Job{
+				Name:     fmt.Sprintf("%s (%s)", schedule.name, strings.TrimSpace(expression)),
+				Schedule: parsed,
+			})
+			scheduledJobOptions = append(scheduledJobOptions, schedule.options)
+		}
+	}
+
+	// QUIET_HOURS holds emails produced in the window, e.g. "21:30-07:00", until it ends
+	if value := os.Getenv("QUIET_HOURS"); value != "" {
+		parsed, err := worker.ParseQuietHours(value)
+		if err != nil {
+			fmt.Println("Invalid QUIET_HOURS:", err)
+			os.Exit(1)
+		}
+		quietHours = parsed
+	}
+
+	// TERM_DATES_FILE is an .ics or JSON calendar, no runs happen in the holidays.
+	// HOLIDAY_MODE=digest sends what was recorded in them when school starts
+	// again, skip (default) drops it.
+	if path := os.Getenv("TERM_DATES_FILE"); path != "" {
+		loaded, err := worker.LoadCalendar(path)
+		if err != nil {
+			fmt.Println("Could not load TERM_DATES_FILE:", err)
+			os.Exit(1)
+		}
+		calendar = loaded
+	}
+
+	switch mode := worker.HolidayMode(os.Getenv("HOLIDAY_MODE")); mode {
+	case "", worker.HolidaySkip, worker.HolidayDigest:
+		holidayMode = mode
+	default:
+		fmt.Println("HOLIDAY_MODE must be skip or digest")
 		os.Exit(1)
 	}
 
-	appCache = cache.New(&common.CacheOptions{
-		CacheType:     common.Redis,
-		RedisHost:     os.Getenv("REDIS_HOST"),
-		RedisUsername: os.Getenv("REDIS_USERNAME"),
-		RedisPassword: os.Getenv("REDIS_PASSWORD"),
-	})
+	var cacheableRequests []edulink.CacheableRequest
+	if value := os.Getenv("EDULINK_CACHEABLE_REQUESTS"); value != "" {
+		parsed, err := edulink.ParseCacheableRequests(value)
+		if err != nil {
+			fmt.Println("Invalid EDULINK_CACHEABLE_REQUESTS:", err)
+			os.Exit(1)
+		}
+		cacheableRequests = parsed
+	}
 
-	edulink.Cache = appCache
+	// EDULINK_RECORDING_MODE=record saves all EduLink traffic, replay serves it back offline
+	var httpClient *http.Client
+	if value := os.Getenv("EDULINK_RECORDING_MODE"); value != "" {
+		mode, err := edulink.ParseRecordingMode(value)
+		if err != nil {
+			fmt.Println("Invalid EDULINK_RECORDING_MODE:", err)
+			os.Exit(1)
+		}
 
-	if err := appCache.Initialise(); err != nil {
-		panic(err)
+		dir := os.Getenv("EDULINK_RECORDING_DIR")
+		if dir == "" {
+			dir = DefaultEdulinkRecordingDir
+		}
+
+		fmt.Printf("EduLink recording mode: %s, fixtures in %s\n", value, dir)
+		httpClient = &http.Client{
+			Transport: edulink.NewRecordingTransport(&edulink.RecordingTransportOptions{
+				Mode: mode,
+				Dir:  dir,
+			}),
+			Timeout: 10 * time.Second,
+		}
+	}
+
+	// resolved schools are cached, so a provisioning outage only affects new
+	// ones. The fake EduLink listens somewhere else every run.
+	if !fakeEdulink {
+		schoolResolver = edulink.NewCachingResolver(&edulink.CachingResolverOptions{
+			Resolver: schoolResolver,
+			Cache:    appCache,
+		})
+	}
+
+	// the schools' clients share one limit on the calls sent to EduLink
+	rateLimiter := edulink.NewRateLimiter(edulink.DefaultRateLimit, edulink.DefaultRateBurst)
+
+	// a school that cannot be resolved is tried again on the next run, the
+	// accounts of the other schools are still reported on
+	var clientsLock sync.Mutex
+	clients := map[string]*edulink.Client{}
+	clientFor := func(ctx context.Context, code string) (*edulink.Client, error) {
+		clientsLock.Lock()
+		defer clientsLock.Unlock()
+
+		if client, ok := clients[code]; ok {
+			return client, nil
+		}
+
+		resolver := schoolResolver
+		if _, ok := staticResolver[code]; ok {
+			resolver = staticResolver
+		}
+
+		client, err := newEdulinkClient(ctx, resolver, code, &edulink.ClientOptions{
+			HTTPClient:        httpClient,
+			Cache:             appCache,
+			RateLimiter:       rateLimiter,
+			CacheableRequests: cacheableRequests,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("resolving EduLink school %s: %w", code, err)
+		}
+		clients[code] = client
+		return client, nil
+	}
+
+	for _, accountConfig := range accountConfigs {
+		accountConfig := accountConfig
+		code := strings.ToLower(accountConfig.SchoolCode)
+
+		account := &worker.Account{
+			Name: accountConfig.Name,
+			NewSession: func(ctx context.Context) (*edulink.Session, error) {
+				client, err := clientFor(ctx, code)
+				if err != nil {
+					return nil, err
+				}
+				return edulink.NewSession(&edulink.SessionOptions{
+					Client:   client,
+					Username: accountConfig.Username,
+					Password: accountConfig.Password,
+					Cache:    appCache,
+				}), nil
+			},
+			Recipients: accountConfig.Recipients,
+			Routes:     accountConfig.Routes,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if _, err := account.Connect(ctx); err != nil {
+			fmt.Printf("Account %s: %s, trying again on the next run\n", accountConfig.Name, err)
+		}
+		cancel()
+
+		edulinkAccounts = append(edulinkAccounts, account)
 	}
 }
 
+// newEdulinkClient resolves the school code and returns a client for it
+func newEdulinkClient(ctx context.Context, resolver edulink.SchoolResolver, code string, options *edulink.ClientOptions) (*edulink.Client, error) {
+	school, err := resolver.Resolve(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("EduLink school %s: %s (establishment %d)\n", school.Code, school.Endpoint, school.EstablishmentID)
+
+	options.School = school
+	return edulink.NewClient(options)
+}
+
 func main() {
 	fmt.Printf("Welcome to EduLink scanner.\n")
 
 	webserverEnabled := flag.Bool("webserver", false, "Enable webserver")
 	webserverPort := flag.Int("port", 8080, "Port to listen on")
+	dryRun := flag.Bool("dry-run", false, "Write emails as .eml files instead of sending them")
+	dryRunDir := flag.String("dry-run-dir", worker.DefaultDryRunDir, "Directory, or Maildir with --dry-run-maildir, for --dry-run output")
+	dryRunMaildir := flag.Bool("dry-run-maildir", false, "Deliver --dry-run output into a Maildir")
+	migrateCache := flag.String("migrate-cache", "", "Copy the cache between Redis and DISK_CACHE_PATH, from-redis or to-redis, or into CACHE_NAMESPACE, to-namespace, and exit")
 
 	flag.Parse()
 
-	webServer := web.NewServer(*webserverPort)
+	setupCache()
+
+	if *migrateCache != "" {
+		if err := migrate(*migrateCache); err != nil {
+			fmt.Println("Cache migration failed:", err)
+			appCache.Close()
+			os.Exit(1)
+		}
+		appCache.Close()
+		return
+	}
+
+	setup()
+
+	deliveries := mailer.NewDeliveryLog(&mailer.DeliveryLogOptions{
+		Cache: appCache,
+	})
+
+	webServer := web.NewServer(&web.ServerOptions{
+		Port:       *webserverPort,
+		Accounts:   edulinkAccounts,
+		Deliveries: deliveries,
+
+		// Mailgun shows the key under Sending > Webhooks
+		MailgunWebhookSigningKey: os.Getenv("MAILGUN_WEBHOOK_SIGNING_KEY"),
+	})
 	if err := webServer.Start(); err != nil {
 		panic(err)
 	}
 
 	workerOptions := &worker.WorkerOptions{
-		EdulinkUsername: EdulinkUsername,
-		EdulinkPassword: EdulinkPassword,
-		Cache:           appCache,
-		MailgunApiKey:   MailgunApiKey,
+		Accounts:   edulinkAccounts,
+		Cache:      appCache,
+		Mailer:     mailerOptions,
+		Deliveries: deliveries,
+		DryRun:     *dryRun,
+		DryRunDir:  *dryRunDir,
+
+		Location:    schedulerLocation,
+		QuietHours:  quietHours,
+		Calendar:    calendar,
+		HolidayMode: holidayMode,
+	}
+
+	if *dryRunMaildir {
+		workerOptions.DryRunFormat = mailer.FileFormatMaildir
+	}
+
+	if mailerOptions.Transport == nil && !*dryRun {
+		fmt.Println("Please set MAILGUN_API_KEY environment variable")
+		os.Exit(1)
+	}
+
+	// every run would report everything again to the parents
+	if memoryOnlyCache && !*dryRun && os.Getenv("SEND_EMAIL") == "true" {
+		fmt.Println("Not sending email with LOCAL_CACHE_SNAPSHOT=none, already seen items would be forgotten")
+		os.Exit(1)
+	}
+
+	// the default snapshot sits in the working directory, which a container
+	// run with --rm throws away with the already seen items
+	if defaultSnapshot && !*dryRun && os.Getenv("SEND_EMAIL") == "true" {
+		fmt.Println("Not sending email without LOCAL_CACHE_SNAPSHOT, set it to a file on a volume, e.g. /data/edulink-cache.json")
+		os.Exit(1)
 	}
 
 	worker := worker.NewWorker(workerOptions)
-	if err := worker.Start(); err != nil {
-		panic(err)
+
+	// the local cache is saved on the way out
+	closeCache := func() {
+		if err := appCache.Close(); err != nil {
+			fmt.Println("Could not save the cache:", err)
+		}
 	}
 
-	wg := sync.WaitGroup{}
+	// SIGTERM comes from docker stop, an in-flight run is drained before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(scheduledJobs) > 0 {
+		for i := range scheduledJobs {
+			options := scheduledJobOptions[i]
+			scheduledJobs[i].Run = func(ctx context.Context) error {
+				return worker.Run(ctx, options)
+			}
+		}
+
+		if quietHours != nil {
+			scheduledJobs = append(scheduledJobs, scheduler.Job{
+				Name:     fmt.Sprintf("release held emails (%s)", quietHours.EndCron()),
+				Schedule: scheduler.MustParse(quietHours.EndCron()),
+				Run:      worker.Release,
+			})
+		}
+
+		fmt.Println("Worker scheduled in", schedulerLocation)
+		err := scheduler.New(&scheduler.SchedulerOptions{
+			Location:     schedulerLocation,
+			Jobs:         scheduledJobs,
+			DrainTimeout: schedulerDrainTimeout,
+		}).Run(ctx)
+
+		webServer.Stop()
+		closeCache()
+		if err != nil {
+			fmt.Println("Scheduler failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// a one-shot run is drained on SIGTERM like a scheduled one
+	err := scheduler.New(&scheduler.SchedulerOptions{
+		DrainTimeout: schedulerDrainTimeout,
+	}).RunOnce(ctx, &scheduler.Job{
+		Name: "report",
+		Run: func(ctx context.Context) error {
+			return worker.Run(ctx, nil)
+		},
+	})
+	if err != nil {
+		fmt.Println("Worker failed:", err)
+		if !*webserverEnabled {
+			closeCache()
+			os.Exit(1)
+		}
+	}
 
 	if *webserverEnabled {
-		wg.Add(1)
 		fmt.Println("Webserver enabled, listening on port", *webserverPort)
-		s := make(chan os.Signal, 1)
-		signal.Notify(s, os.Interrupt)
+		<-ctx.Done()
+		webServer.Stop()
+	}
+
+	closeCache()
+}
+
+// migrate copies the disk cache from or to Redis, appCache is the disk cache
+// as DISK_CACHE_PATH takes precedence over Redis. to-namespace copies the keys
+// written before CACHE_NAMESPACE was set into it.
+func migrate(direction string) error {
+	if direction == "to-namespace" {
+		if appCache.Prefix() == "" {
+			return errors.New("--migrate-cache to-namespace needs CACHE_NAMESPACE")
+		}
+
+		_, err := cache.MigrateKeys(context.Background(), appCache.Unscoped(), appCache, unprefixedKey)
+		return err
+	}
+
+	if os.Getenv("DISK_CACHE_PATH") == "" || !redisConfigured() {
+		return errors.New("--migrate-cache needs both Redis and DISK_CACHE_PATH")
+	}
 
-		go func() {
-			<-s
-			wg.Done()
-			webServer.Stop()
-		}()
+	redisOptions, err := redisCacheOptions()
+	if err != nil {
+		return err
+	}
 
-		wg.Wait()
+	redisCache := cache.New(redisOptions)
+	if err := redisCache.Initialise(); err != nil {
+		return err
 	}
+
+	switch direction {
+	case "from-redis":
+		_, err = cache.Migrate(context.Background(), redisCache, appCache)
+	case "to-redis":
+		_, err = cache.Migrate(context.Background(), appCache, redisCache)
+	default:
+		err = fmt.Errorf("unknown direction %q, expected from-redis, to-redis or to-namespace", direction)
+	}
+	return err
+}
+
+// keyKinds start the keys written without a namespace, the keys of other
+// namespaces start with their namespace instead
+var keyKinds = []string{
+	"account", "alreadySeenAchievementIDs", "alreadySeenBehaviourIDs",
+	"deliveries", "delivery", "outbox", "school", "session", "set",
+}
+
+// unprefixedKey tells the keys written without a namespace, cached EduLink
+// responses start with their method, e.g. EduLink.Achievement
+func unprefixedKey(key string) bool {
+	kind, _, _ := strings.Cut(key, ":")
+	return slices.Contains(keyKinds, kind) || strings.HasPrefix(kind, "EduLink.")
+}
+
+func redisConfigured() bool {
+	return os.Getenv("REDIS_HOST") != "" || os.Getenv("REDIS_URL") != "" || os.Getenv("REDIS_SENTINEL_ADDRS") != ""
+}
+
+// redisCacheOptions reads the Redis connection from the environment: REDIS_URL,
+// or REDIS_HOST with REDIS_PORT, or REDIS_SENTINEL_ADDRS with
+// REDIS_SENTINEL_MASTER. TLS is turned on by a rediss:// URL or REDIS_TLS=true.
+func redisCacheOptions() (*common.CacheOptions, error) {
+	options := &common.CacheOptions{
+		CacheType: common.Redis,
+
+		// CACHE_NAMESPACE lets deployments share a Redis without sharing keys.
+		// Keys written before it was set are not read, copy them into it with
+		// --migrate-cache to-namespace before the Redis is shared.
+		Namespace: os.Getenv("CACHE_NAMESPACE"),
+
+		RedisURL:              os.Getenv("REDIS_URL"),
+		RedisHost:             os.Getenv("REDIS_HOST"),
+		RedisUsername:         os.Getenv("REDIS_USERNAME"),
+		RedisPassword:         os.Getenv("REDIS_PASSWORD"),
+		RedisTLSCAFile:        os.Getenv("REDIS_TLS_CA_FILE"),
+		RedisTLSServerName:    os.Getenv("REDIS_TLS_SERVER_NAME"),
+		RedisSentinelMaster:   os.Getenv("REDIS_SENTINEL_MASTER"),
+		RedisSentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+	}
+
+	if value := os.Getenv("REDIS_SENTINEL_ADDRS"); value != "" {
+		for _, addr := range strings.Split(value, ",") {
+			options.RedisSentinelAddrs = append(options.RedisSentinelAddrs, strings.TrimSpace(addr))
+		}
+	}
+
+	ints := []struct {
+		env   string
+		value *int
+	}{
+		{"REDIS_PORT", &options.RedisPort},
+	}
+	for _, i := range ints {
+		if value := os.Getenv(i.env); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", i.env)
+			}
+			*i.value = parsed
+		}
+	}
+
+	// REDIS_DB=0 overrides the DB of REDIS_URL too
+	if value := os.Getenv("REDIS_DB"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("REDIS_DB must be a number")
+		}
+		options.RedisDB = &parsed
+	}
+
+	bools := []struct {
+		env   string
+		value *bool
+	}{
+		{"REDIS_TLS", &options.RedisTLS},
+		{"REDIS_TLS_INSECURE_SKIP_VERIFY", &options.RedisTLSInsecureSkipVerify},
+	}
+	for _, b := range bools {
+		if value := os.Getenv(b.env); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be true or false", b.env)
+			}
+			*b.value = parsed
+		}
+	}
+
+	return options, nil
 }
@@ -0,0 +1,18 @@
+//go:build !fake
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+)
+
+// startFakeEdulink exits, the fake EduLink is only built with -tags fake so
+// its test server stays out of the production binary
+func startFakeEdulink() (edulink.SchoolResolver, string, string, string) {
+	fmt.Println("EDULINK_FAKE needs a build with -tags fake")
+	os.Exit(1)
+	return nil, "", "", ""
+}
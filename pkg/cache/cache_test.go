@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache/common"
+)
+
+// testBackends returns one cache per backend, Redis only when REDIS_TEST_URL
+// is set. Each cache has a prefix of its own, so a shared Redis is left as
+// it was.
+func testBackends() map[string]func(t *testing.T) *Cache {
+	return map[string]func(t *testing.T) *Cache{
+		"local": func(t *testing.T) *Cache {
+			return newBackend(t, &common.CacheOptions{CacheType: common.Local})
+		},
+		"disk": func(t *testing.T) *Cache {
+			return newBackend(t, &common.CacheOptions{CacheType: common.Disk, DiskPath: filepath.Join(t.TempDir(), "cache.db")})
+		},
+		"redis": func(t *testing.T) *Cache {
+			url := os.Getenv("REDIS_TEST_URL")
+			if url == "" {
+				t.Skip("REDIS_TEST_URL is not set")
+			}
+
+			c := newBackend(t, &common.CacheOptions{
+				CacheType: common.Redis,
+				RedisURL:  url,
+				Namespace: fmt.Sprintf("edulinktest:%d", time.Now().UnixNano()),
+			})
+			t.Cleanup(func() {
+				keys, _ := c.Scan(context.Background(), "")
+				for _, key := range keys {
+					c.Delete(context.Background(), key)
+				}
+			})
+			return c
+		},
+	}
+}
+
+func newBackend(t *testing.T, options *common.CacheOptions) *Cache {
+	t.Helper()
+
+	c := New(options)
+	if err := c.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestBackendValues(t *testing.T) {
+	for name, newCache := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newCache(t)
+
+			if err := c.Set(&common.Item{Ctx: ctx, Key: "value", Value: "one", TTL: time.Hour}); err != nil {
+				t.Fatal(err)
+			}
+
+			var value string
+			if err := c.Get(ctx, "value", &value); err != nil || value != "one" {
+				t.Errorf("Get = %q, %v, want one", value, err)
+			}
+			if !c.Exists(ctx, "value") {
+				t.Error("value does not exist")
+			}
+
+			if err := c.Delete(ctx, "value"); err != nil {
+				t.Fatal(err)
+			}
+			if c.Exists(ctx, "value") {
+				t.Error("value exists after Delete")
+			}
+			if err := c.Get(ctx, "value", &value); !errors.Is(err, common.ErrCacheMiss) {
+				t.Errorf("Get after Delete = %v, want ErrCacheMiss", err)
+			}
+			if err := c.Delete(ctx, "missing"); err != nil {
+				t.Errorf("Delete of a missing key = %v", err)
+			}
+		})
+	}
+}
+
+func TestBackendTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(ctx context.Context, c *Cache) error
+		// want is the TTL left, at most and a minute below it
+		want time.Duration
+	}{
+		{
+			name: "value",
+			set: func(ctx context.Context, c *Cache) error {
+				return c.Set(&common.Item{Ctx: ctx, Key: "key", Value: 1, TTL: 2 * time.Hour})
+			},
+			want: 2 * time.Hour,
+		},
+		{
+			name: "value without a TTL",
+			set: func(ctx context.Context, c *Cache) error {
+				return c.Set(&common.Item{Ctx: ctx, Key: "key", Value: 1})
+			},
+			want: common.DefaultTTL,
+		},
+		{
+			name: "set",
+			set: func(ctx context.Context, c *Cache) error {
+				return c.SetAdd(ctx, "key", 2*time.Hour, "a1")
+			},
+			want: 2 * time.Hour,
+		},
+		{
+			name: "set without a TTL never expires",
+			set: func(ctx context.Context, c *Cache) error {
+				return c.SetAdd(ctx, "key", 0, "a1")
+			},
+		},
+		{
+			name: "set extended by the last add",
+			set: func(ctx context.Context, c *Cache) error {
+				if err := c.SetAdd(ctx, "key", time.Hour, "a1"); err != nil {
+					return err
+				}
+				return c.SetAdd(ctx, "key", 3*time.Hour, "a2")
+			},
+			want: 3 * time.Hour,
+		},
+		{
+			name: "set keeps its expiry on an add without a TTL",
+			set: func(ctx context.Context, c *Cache) error {
+				if err := c.SetAdd(ctx, "key", time.Hour, "a1"); err != nil {
+					return err
+				}
+				return c.SetAdd(ctx, "key", 0, "a2")
+			},
+			want: time.Hour,
+		},
+	}
+
+	for name, newCache := range testBackends() {
+		for _, test := range tests {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				ctx := context.Background()
+				c := newCache(t)
+
+				if err := test.set(ctx, c); err != nil {
+					t.Fatal(err)
+				}
+
+				ttl, err := c.TTL(ctx, "key")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ttl > test.want || ttl < test.want-time.Minute {
+					t.Errorf("TTL = %s, want %s", ttl, test.want)
+				}
+			})
+		}
+
+		t.Run(name+" missing", func(t *testing.T) {
+			if _, err := newCache(t).TTL(context.Background(), "missing"); !errors.Is(err, common.ErrCacheMiss) {
+				t.Errorf("TTL of a missing key = %v, want ErrCacheMiss", err)
+			}
+		})
+	}
+}
+
+func TestBackendSets(t *testing.T) {
+	for name, newCache := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newCache(t)
+
+			if err := c.SetAdd(ctx, "set", 0, "b", "a"); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.SetAdd(ctx, "set", 0, "c", "a"); err != nil {
+				t.Fatal(err)
+			}
+
+			members, err := c.SetMembers(ctx, "set")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"a", "b", "c"}; !slices.Equal(members, want) {
+				t.Errorf("SetMembers = %v, want %v", members, want)
+			}
+
+			for member, want := range map[string]bool{"a": true, "c": true, "d": false} {
+				if got, err := c.SetContains(ctx, "set", member); err != nil || got != want {
+					t.Errorf("SetContains(%s) = %t, %v, want %t", member, got, err, want)
+				}
+			}
+
+			if !c.Exists(ctx, "set") {
+				t.Error("set does not exist")
+			}
+
+			members, err = c.SetMembers(ctx, "missing")
+			if err != nil || len(members) != 0 {
+				t.Errorf("SetMembers of a missing set = %v, %v, want none", members, err)
+			}
+
+			if err := c.Set(&common.Item{Ctx: ctx, Key: "value", Value: "one", TTL: time.Hour}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.SetMembers(ctx, "value"); !errors.Is(err, common.ErrWrongType) {
+				t.Errorf("SetMembers of a value = %v, want ErrWrongType", err)
+			}
+			var value string
+			if err := c.Get(ctx, "set", &value); !errors.Is(err, common.ErrWrongType) {
+				t.Errorf("Get of a set = %v, want ErrWrongType", err)
+			}
+
+			if err := c.Delete(ctx, "set"); err != nil {
+				t.Fatal(err)
+			}
+			if c.Exists(ctx, "set") {
+				t.Error("set exists after Delete")
+			}
+		})
+	}
+}
+
+func TestBackendScan(t *testing.T) {
+	for name, newCache := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newCache(t)
+			account := c.WithPrefix("account:parent")
+
+			for _, key := range []string{"outbox:sent:2", "outbox:sent:1", "outbox", "response*"} {
+				if err := c.Set(&common.Item{Ctx: ctx, Key: key, Value: key, TTL: time.Hour}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := c.SetAdd(ctx, "outbox:sent:set", 0, "a1"); err != nil {
+				t.Fatal(err)
+			}
+			if err := account.Set(&common.Item{Ctx: ctx, Key: "outbox:sent:3", Value: "3", TTL: time.Hour}); err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				cache  *Cache
+				prefix string
+				want   []string
+			}{
+				{c, "outbox:sent:", []string{"outbox:sent:1", "outbox:sent:2", "outbox:sent:set"}},
+				{c, "outbox", []string{"outbox", "outbox:sent:1", "outbox:sent:2", "outbox:sent:set"}},
+				// glob characters are matched as they are
+				{c, "response*", []string{"response*"}},
+				{c, "resp*", []string{}},
+				{c, "missing", []string{}},
+				// keys come back without the prefix of the cache
+				{account, "outbox:sent:", []string{"outbox:sent:3"}},
+				{c, "account:parent:", []string{"account:parent:outbox:sent:3"}},
+			}
+			for _, test := range tests {
+				keys, err := test.cache.Scan(ctx, test.prefix)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(keys, test.want) {
+					t.Errorf("Scan(%q) with prefix %q = %v, want %v", test.prefix, test.cache.Prefix(), keys, test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package common
+
+import (
+	cachev9 "github.com/go-redis/cache/v9"
+)
+
+// codec serialises values the way go-redis/cache does, so every backend
+// stores the same bytes as Redis
+var codec = cachev9.New(&cachev9.Options{})
+
+func Marshal(value interface{}) ([]byte, error) {
+	return codec.Marshal(value)
+}
+
+func Unmarshal(b []byte, value interface{}) error {
+	return codec.Unmarshal(b, value)
+}
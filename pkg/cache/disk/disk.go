@@ -0,0 +1,372 @@
+package disk
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache/common"
+	"github.com/eu-evops/edulink/pkg/cache/local"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrCacheMiss is returned by Get for keys that are missing or expired
+var ErrCacheMiss = common.ErrCacheMiss
+
+var (
+	bucket = []byte("cache")
+
+	// sets are stored as serialised member lists, apart from the values
+	setBucket = []byte("sets")
+)
+
+// DiskCache keeps values in a bbolt database file, serialised like the Redis
+// backend does. Only one process can open the file, so leases are held in
+// memory, see local.Locker.
+type DiskCache struct {
+	*local.Locker
+
+	db      *bolt.DB
+	options *common.CacheOptions
+}
+
+func New(options *common.CacheOptions) *DiskCache {
+	return &DiskCache{
+		Locker:  local.NewLocker(),
+		options: options,
+	}
+}
+
+// Initialise opens the database and drops the expired entries
+func (c *DiskCache) Initialise() error {
+	path := c.options.DiskPath
+	if path == "" {
+		return errors.New("disk cache: no DiskPath")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return fmt.Errorf("disk cache: opening %s: %w", path, err)
+	}
+	c.db = db
+
+	expired := 0
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for _, name := range [][]byte{bucket, setBucket} {
+			b, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+
+			cursor := b.Cursor()
+			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+				if _, ok := decode(value, now); ok {
+					continue
+				}
+
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+				expired++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Opened disk cache %s, dropped %d expired entries\n", path, expired)
+	return nil
+}
+
+func (c *DiskCache) Close() error {
+	if c.db == nil {
+		return nil
+	}
+	return c.db.Close()
+}
+
+// entries are the expiry time in Unix nanoseconds, zero for never, followed
+// by the serialised value
+func encode(data []byte, ttl time.Duration) []byte {
+	value := make([]byte, 8+len(data))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(value, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	copy(value[8:], data)
+	return value
+}
+
+// decode returns the serialised value and whether it is still live
+func decode(value []byte, now time.Time) ([]byte, bool) {
+	if len(value) < 8 {
+		return nil, false
+	}
+
+	expires := int64(binary.BigEndian.Uint64(value))
+	if expires != 0 && now.UnixNano() >= expires {
+		return nil, false
+	}
+
+	return value[8:], true
+}
+
+// remaining is the ttl left on an entry, zero when it never expires
+func remaining(value []byte, now time.Time) time.Duration {
+	expires := int64(binary.BigEndian.Uint64(value))
+	if expires == 0 {
+		return 0
+	}
+	return time.Unix(0, expires).Sub(now)
+}
+
+func (c *DiskCache) get(name []byte, key string) ([]byte, bool) {
+	var data []byte
+	var ok bool
+
+	c.db.View(func(tx *bolt.Tx) error {
+		var value []byte
+		if value, ok = decode(tx.Bucket(name).Get([]byte(key)), time.Now()); ok {
+			// values are only valid during the transaction
+			data = append([]byte{}, value...)
+		}
+		return nil
+	})
+
+	return data, ok
+}
+
+func (c *DiskCache) Get(ctx context.Context, key string, value interface{}) error {
+	data, ok := c.get(bucket, key)
+	if !ok {
+		if _, isSet := c.get(setBucket, key); isSet {
+			return common.ErrWrongType
+		}
+		return ErrCacheMiss
+	}
+
+	return common.Unmarshal(data, value)
+}
+
+func (c *DiskCache) Set(item *common.Item) error {
+	data, err := common.Marshal(item.Value)
+	if err != nil {
+		return err
+	}
+
+	ttl := item.Expiration()
+	if ttl == 0 {
+		return nil
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(setBucket).Delete([]byte(item.Key)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucket).Put([]byte(item.Key), encode(data, ttl))
+	})
+}
+
+func (c *DiskCache) Exists(ctx context.Context, key string) bool {
+	_, ok := c.get(bucket, key)
+	if !ok {
+		_, ok = c.get(setBucket, key)
+	}
+	return ok
+}
+
+func (c *DiskCache) Delete(ctx context.Context, key string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucket).Delete([]byte(key)); err != nil {
+			return err
+		}
+		return tx.Bucket(setBucket).Delete([]byte(key))
+	})
+}
+
+func (c *DiskCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := c.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for _, name := range [][]byte{bucket, setBucket} {
+			value := tx.Bucket(name).Get([]byte(key))
+			if _, ok := decode(value, now); ok {
+				ttl = remaining(value, now)
+				return nil
+			}
+		}
+		return ErrCacheMiss
+	})
+	return ttl, err
+}
+
+func (c *DiskCache) Scan(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for _, name := range [][]byte{bucket, setBucket} {
+			// keys are kept in byte order, so those with the prefix are together
+			cursor := tx.Bucket(name).Cursor()
+			for key, value := cursor.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, value = cursor.Next() {
+				if _, ok := decode(value, now); ok {
+					keys = append(keys, string(key))
+				}
+			}
+		}
+		return nil
+	})
+
+	sort.Strings(keys)
+	return keys, err
+}
+
+// members reads the live set at key from tx, nil when there is none
+func members(tx *bolt.Tx, key string) (map[string]struct{}, []byte, error) {
+	value := tx.Bucket(setBucket).Get([]byte(key))
+	data, ok := decode(value, time.Now())
+	if !ok {
+		if _, isValue := decode(tx.Bucket(bucket).Get([]byte(key)), time.Now()); isValue {
+			return nil, nil, common.ErrWrongType
+		}
+		return nil, nil, nil
+	}
+
+	list := []string{}
+	if err := common.Unmarshal(data, &list); err != nil {
+		return nil, nil, err
+	}
+
+	set := map[string]struct{}{}
+	for _, member := range list {
+		set[member] = struct{}{}
+	}
+	return set, value, nil
+}
+
+func (c *DiskCache) SetAdd(ctx context.Context, key string, ttl time.Duration, added ...string) error {
+	// like Redis, there are no empty sets
+	if len(added) == 0 {
+		return nil
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		set, value, err := members(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if set == nil {
+			set = map[string]struct{}{}
+		} else if ttl == 0 {
+			ttl = remaining(value, time.Now())
+		}
+
+		for _, member := range added {
+			set[member] = struct{}{}
+		}
+
+		list := make([]string, 0, len(set))
+		for member := range set {
+			list = append(list, member)
+		}
+		sort.Strings(list)
+
+		data, err := common.Marshal(list)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(setBucket).Put([]byte(key), encode(data, ttl))
+	})
+}
+
+func (c *DiskCache) SetContains(ctx context.Context, key string, member string) (bool, error) {
+	contains := false
+	err := c.db.View(func(tx *bolt.Tx) error {
+		set, _, err := members(tx, key)
+		_, contains = set[member]
+		return err
+	})
+	return contains, err
+}
+
+func (c *DiskCache) SetMembers(ctx context.Context, key string) ([]string, error) {
+	list := []string{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		set, _, err := members(tx, key)
+		for member := range set {
+			list = append(list, member)
+		}
+		return err
+	})
+
+	sort.Strings(list)
+	return list, err
+}
+
+// Each calls fn with every live entry, as stored
+func (c *DiskCache) Each(ctx context.Context, fn func(entry *common.RawEntry) error) error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		for _, name := range [][]byte{bucket, setBucket} {
+			isSet := bytes.Equal(name, setBucket)
+
+			err := tx.Bucket(name).ForEach(func(key []byte, value []byte) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				data, ok := decode(value, now)
+				if !ok {
+					return nil
+				}
+
+				entry := &common.RawEntry{
+					Key:   string(key),
+					IsSet: isSet,
+					TTL:   remaining(value, now),
+				}
+				if isSet {
+					if err := common.Unmarshal(data, &entry.Members); err != nil {
+						return err
+					}
+				} else {
+					entry.Data = append([]byte{}, data...)
+				}
+				return fn(entry)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *DiskCache) SetRaw(ctx context.Context, entry *common.RawEntry) error {
+	if entry.IsSet {
+		if err := c.Delete(ctx, entry.Key); err != nil {
+			return err
+		}
+		return c.SetAdd(ctx, entry.Key, entry.TTL, entry.Members...)
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(setBucket).Delete([]byte(entry.Key)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucket).Put([]byte(entry.Key), encode(entry.Data, entry.TTL))
+	})
+}
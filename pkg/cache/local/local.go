@@ -0,0 +1,368 @@
+package local
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache/common"
+)
+
+// DefaultMaxEntries bounds the evictable entries when no LocalMaxEntries is given
+const DefaultMaxEntries = 10000
+
+// ErrCacheMiss is returned by Get for keys that are missing or expired
+var ErrCacheMiss = common.ErrCacheMiss
+
+// entry is a serialised value, or a set when Members is not nil
+type entry struct {
+	Key     string
+	Data    []byte              `json:",omitempty"`
+	Members map[string]struct{} `json:",omitempty"`
+
+	// Expires is zero for entries that never expire
+	Expires time.Time
+
+	// Evictable entries count towards maxEntries, see common.Item
+	Evictable bool `json:",omitempty"`
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+func (e *entry) isSet() bool {
+	return e.Members != nil
+}
+
+// LocalCache keeps values in memory, serialised like the Redis backend does.
+// It holds leases for the process, see Locker.
+type LocalCache struct {
+	*Locker
+
+	options    *common.CacheOptions
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// recency keeps the most recently used entry at the front
+	recency *list.List
+	// evictable counts the entries in recency that may be evicted
+	evictable int
+}
+
+func New(options *common.CacheOptions) *LocalCache {
+	maxEntries := options.LocalMaxEntries
+	if maxEntries == 0 {
+		maxEntries = DefaultMaxEntries
+	}
+
+	return &LocalCache{
+		Locker:     NewLocker(),
+		options:    options,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		recency:    list.New(),
+	}
+}
+
+// Initialise loads the snapshot, when there is one
+func (c *LocalCache) Initialise() error {
+	path := c.options.LocalSnapshotPath
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("No cache snapshot at %s yet\n", path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entries := []*entry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// the snapshot lists the most recently used entry first
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].expired(now) {
+			c.put(entries[i])
+		}
+	}
+
+	log.Printf("Loaded %d cache entries from %s\n", len(c.entries), path)
+	return nil
+}
+
+// Close saves the snapshot, see Save
+func (c *LocalCache) Close() error {
+	return c.Save()
+}
+
+// Save writes the snapshot, when a path for it is configured
+func (c *LocalCache) Save() error {
+	path := c.options.LocalSnapshotPath
+	if path == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	entries := []*entry{}
+	for element := c.recency.Front(); element != nil; element = element.Next() {
+		if e := element.Value.(*entry); !e.expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// written next to the snapshot and renamed, so a crash leaves the old one
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("Saving %d cache entries to %s\n", len(entries), path)
+	return os.Rename(tmp.Name(), path)
+}
+
+// put stores an entry as the most recently used one, evicting the least
+// recently used evictable entries beyond maxEntries. Other entries, like the
+// already seen sets and the outbox, are kept until they expire. c.mu must be
+// held.
+func (c *LocalCache) put(e *entry) {
+	if element, ok := c.entries[e.Key]; ok {
+		c.remove(element)
+	}
+
+	c.entries[e.Key] = c.recency.PushFront(e)
+	if e.Evictable {
+		c.evictable++
+	}
+
+	for element := c.recency.Back(); element != nil && c.evictable > c.maxEntries; {
+		previous := element.Prev()
+		if element.Value.(*entry).Evictable {
+			c.remove(element)
+		}
+		element = previous
+	}
+}
+
+// remove drops an entry. c.mu must be held.
+func (c *LocalCache) remove(element *list.Element) {
+	e := element.Value.(*entry)
+	if e.Evictable {
+		c.evictable--
+	}
+
+	c.recency.Remove(element)
+	delete(c.entries, e.Key)
+}
+
+// get returns the live entry for key, dropping it when it expired. c.mu must
+// be held.
+func (c *LocalCache) get(key string) *entry {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	e := element.Value.(*entry)
+	if e.expired(time.Now()) {
+		c.remove(element)
+		return nil
+	}
+
+	c.recency.MoveToFront(element)
+	return e
+}
+
+func (c *LocalCache) Get(ctx context.Context, key string, value interface{}) error {
+	c.mu.Lock()
+	e := c.get(key)
+	c.mu.Unlock()
+
+	if e == nil {
+		return ErrCacheMiss
+	}
+	if e.isSet() {
+		return common.ErrWrongType
+	}
+
+	return common.Unmarshal(e.Data, value)
+}
+
+func (c *LocalCache) Set(item *common.Item) error {
+	data, err := common.Marshal(item.Value)
+	if err != nil {
+		return err
+	}
+
+	ttl := item.Expiration()
+	if ttl == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.put(&entry{
+		Key:       item.Key,
+		Data:      data,
+		Expires:   time.Now().Add(ttl),
+		Evictable: item.Evictable,
+	})
+	return nil
+}
+
+func (c *LocalCache) Exists(ctx context.Context, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(key) != nil
+}
+
+func (c *LocalCache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	return nil
+}
+
+func (c *LocalCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.get(key)
+	if e == nil {
+		return 0, ErrCacheMiss
+	}
+	if e.Expires.IsZero() {
+		return 0, nil
+	}
+	return time.Until(e.Expires), nil
+}
+
+func (c *LocalCache) Scan(ctx context.Context, prefix string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	keys := []string{}
+	for key, element := range c.entries {
+		if strings.HasPrefix(key, prefix) && !element.Value.(*entry).expired(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// set returns the live set at key, nil when there is none. c.mu must be held.
+func (c *LocalCache) set(key string) (*entry, error) {
+	e := c.get(key)
+	if e != nil && !e.isSet() {
+		return nil, common.ErrWrongType
+	}
+	return e, nil
+}
+
+func (c *LocalCache) SetAdd(ctx context.Context, key string, ttl time.Duration, members ...string) error {
+	// like Redis, there are no empty sets
+	if len(members) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.set(key)
+	if err != nil {
+		return err
+	}
+
+	// entries are replaced rather than changed, snapshots may be reading them
+	added := &entry{Key: key, Members: map[string]struct{}{}}
+	if e != nil {
+		added.Expires = e.Expires
+		for member := range e.Members {
+			added.Members[member] = struct{}{}
+		}
+	}
+
+	for _, member := range members {
+		added.Members[member] = struct{}{}
+	}
+	if ttl > 0 {
+		added.Expires = time.Now().Add(ttl)
+	}
+
+	c.put(added)
+	return nil
+}
+
+func (c *LocalCache) SetContains(ctx context.Context, key string, member string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.set(key)
+	if err != nil || e == nil {
+		return false, err
+	}
+
+	_, ok := e.Members[member]
+	return ok, nil
+}
+
+func (c *LocalCache) SetMembers(ctx context.Context, key string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, err := c.set(key)
+	if err != nil || e == nil {
+		return []string{}, err
+	}
+
+	members := make([]string, 0, len(e.Members))
+	for member := range e.Members {
+		members = append(members, member)
+	}
+
+	sort.Strings(members)
+	return members, nil
+}
@@ -0,0 +1,91 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache/common"
+)
+
+func TestEvictsOnlyEvictableEntries(t *testing.T) {
+	ctx := context.Background()
+	c := New(&common.CacheOptions{LocalMaxEntries: 2})
+
+	// state written first is the least recently used
+	if err := c.SetAdd(ctx, "set:seen", 0, "a1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(&common.Item{Key: "outbox", Value: "entries", TTL: 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := c.Set(&common.Item{Key: fmt.Sprintf("response:%d", i), Value: i, TTL: time.Hour, Evictable: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"set:seen", true},
+		{"outbox", true},
+		{"response:0", false},
+		{"response:1", false},
+		{"response:2", false},
+		{"response:3", true},
+		{"response:4", true},
+	}
+	for _, test := range tests {
+		if got := c.Exists(ctx, test.key); got != test.want {
+			t.Errorf("Exists(%s) = %t, want %t", test.key, got, test.want)
+		}
+	}
+
+	// replacing an evictable entry with state frees its place
+	if err := c.Set(&common.Item{Key: "response:3", Value: "state", TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(&common.Item{Key: "response:5", Value: 5, TTL: time.Hour, Evictable: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"response:3", "response:4", "response:5"} {
+		if !c.Exists(ctx, key) {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+}
+
+func TestSnapshotKeepsEvictable(t *testing.T) {
+	ctx := context.Background()
+	options := &common.CacheOptions{
+		LocalMaxEntries:   1,
+		LocalSnapshotPath: filepath.Join(t.TempDir(), "cache.json"),
+	}
+
+	c := New(options)
+	if err := c.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	c.Set(&common.Item{Key: "state", Value: 1, TTL: time.Hour})
+	c.Set(&common.Item{Key: "response:1", Value: 1, TTL: time.Hour, Evictable: true})
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := New(options)
+	if err := loaded.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	loaded.Set(&common.Item{Key: "response:2", Value: 2, TTL: time.Hour, Evictable: true})
+
+	for key, want := range map[string]bool{"state": true, "response:1": false, "response:2": true} {
+		if got := loaded.Exists(ctx, key); got != want {
+			t.Errorf("Exists(%s) = %t, want %t", key, got, want)
+		}
+	}
+}
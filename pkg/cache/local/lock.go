@@ -0,0 +1,59 @@
+package local
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type lease struct {
+	owner   string
+	expires time.Time
+}
+
+// Locker holds leases in memory, they only exclude holders in the same process
+type Locker struct {
+	mu     sync.Mutex
+	leases map[string]lease
+}
+
+func NewLocker() *Locker {
+	return &Locker{
+		leases: map[string]lease{},
+	}
+}
+
+func (l *Locker) Acquire(ctx context.Context, key string, owner string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.leases[key]; ok && time.Now().Before(current.expires) {
+		return false, nil
+	}
+
+	l.leases[key] = lease{owner: owner, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (l *Locker) Renew(ctx context.Context, key string, owner string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok := l.leases[key]
+	if !ok || current.owner != owner || !time.Now().Before(current.expires) {
+		return false, nil
+	}
+
+	l.leases[key] = lease{owner: owner, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (l *Locker) Release(ctx context.Context, key string, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.leases[key]; ok && current.owner == owner {
+		delete(l.leases, key)
+	}
+	return nil
+}
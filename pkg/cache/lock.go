@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache/common"
+	"github.com/eu-evops/edulink/pkg/cache/local"
+)
+
+const (
+	// DefaultLockTTL is how long a lease lasts when its holder stops renewing it
+	DefaultLockTTL = 30 * time.Second
+
+	lockRetryInterval = 250 * time.Millisecond
+)
+
+// ErrLocked is returned when another owner holds the lock
+var ErrLocked = errors.New("cache: locked by another owner")
+
+// caches without leases of their own lock within the process
+var localLocker = local.NewLocker()
+
+type LockOptions struct {
+	// TTL of the lease, it is renewed every third of it while held. Defaults
+	// to DefaultLockTTL.
+	TTL time.Duration
+
+	// Wait is how long to wait for another owner to release the lock, zero
+	// returns ErrLocked straight away
+	Wait time.Duration
+}
+
+// Lock is a held lease, renewed in the background until Unlock
+type Lock struct {
+	locker common.LockInt
+	key    string
+	owner  string
+	ttl    time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	lost   bool
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *Cache) locker() common.LockInt {
+	if c != nil {
+		if locker, ok := c.cache.(common.LockInt); ok {
+			return locker
+		}
+	}
+	return localLocker
+}
+
+// Lock takes the lease called name. Every process sharing the cache competes
+// for the same lease; a nil cache, or one that cannot hold leases, only
+// excludes other holders in this process.
+func (c *Cache) Lock(ctx context.Context, name string, o *LockOptions) (*Lock, error) {
+	if o == nil {
+		o = &LockOptions{}
+	}
+
+	ttl := o.TTL
+	if ttl == 0 {
+		ttl = DefaultLockTTL
+	}
+
+	l := &Lock{
+		locker: c.locker(),
+		key:    c.key(fmt.Sprintf("lock:%s", name)),
+		owner:  lockOwner(),
+		ttl:    ttl,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	deadline := time.Now().Add(o.Wait)
+	for {
+		acquired, err := l.locker.Acquire(ctx, l.key, l.owner, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, ErrLocked
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	l.ctx, l.cancel = context.WithCancel(ctx)
+	go l.renew()
+
+	return l, nil
+}
+
+// renew keeps the lease alive. The lock is lost, and its context cancelled,
+// when another owner took the lease or it could not be renewed before it ran out.
+func (l *Lock) renew() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ok, err := l.locker.Renew(context.Background(), l.key, l.owner, l.ttl)
+		if err == nil && ok {
+			renewed = time.Now()
+			continue
+		}
+
+		if err != nil && time.Since(renewed) < l.ttl {
+			log.Printf("Could not renew %s, retrying: %s\n", l.key, err)
+			continue
+		}
+
+		log.Printf("Lost %s\n", l.key)
+		l.lost = true
+		l.cancel()
+		return
+	}
+}
+
+// Context is cancelled when the lock is lost or unlocked, work done under the
+// lock should stop then
+func (l *Lock) Context() context.Context {
+	return l.ctx
+}
+
+// Unlock releases the lease, calling it more than once is harmless
+func (l *Lock) Unlock() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+
+		if !l.lost {
+			err = l.locker.Release(context.Background(), l.key, l.owner)
+		}
+		l.cancel()
+	})
+	return err
+}
+
+func lockOwner() string {
+	hostname, _ := os.Hostname()
+
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), hex.EncodeToString(b))
+}
+
+// freshGetter is implemented by caches keeping a local copy of remote values
+type freshGetter interface {
+	GetSkippingLocalCache(ctx context.Context, key string, value interface{}) error
+}
+
+// Update reads key into value, lets update change value and writes it back.
+// It holds a lease on the key meanwhile, so concurrent updates from other
+// processes are not lost. value is left alone when the key does not exist.
+func (c *Cache) Update(ctx context.Context, key string, value interface{}, ttl time.Duration, update func() error) error {
+	lock, err := c.Lock(ctx, fmt.Sprintf("update:%s", key), &LockOptions{
+		TTL:  10 * time.Second,
+		Wait: time.Minute,
+	})
+	if err != nil {
+		return fmt.Errorf("locking %s: %w", key, err)
+	}
+	defer lock.Unlock()
+
+	if c.Exists(ctx, key) {
+		if fresh, ok := c.cache.(freshGetter); ok {
+			err = fresh.GetSkippingLocalCache(ctx, c.key(key), value)
+		} else {
+			err = c.Get(ctx, key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := update(); err != nil {
+		return err
+	}
+
+	return c.Set(&common.Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: value,
+		TTL:   ttl,
+	})
+}
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache/common"
+)
+
+func newLockCache(t *testing.T) *Cache {
+	t.Helper()
+
+	// leases of caches without their own are shared by the process, the
+	// prefix keeps each test's apart
+	return newBackend(t, &common.CacheOptions{
+		CacheType: common.Local,
+		Namespace: fmt.Sprintf("locktest:%d", time.Now().UnixNano()),
+	})
+}
+
+func TestLockContention(t *testing.T) {
+	ctx := context.Background()
+	c := newLockCache(t)
+
+	held, err := c.Lock(ctx, "run", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Lock(ctx, "run", nil); !errors.Is(err, ErrLocked) {
+		t.Errorf("second Lock = %v, want ErrLocked", err)
+	}
+	if _, err := c.Lock(ctx, "run", &LockOptions{Wait: 300 * time.Millisecond}); !errors.Is(err, ErrLocked) {
+		t.Errorf("second Lock after waiting = %v, want ErrLocked", err)
+	}
+
+	other, err := c.Lock(ctx, "other", nil)
+	if err != nil {
+		t.Errorf("Lock of another name = %v", err)
+	} else {
+		other.Unlock()
+	}
+
+	time.AfterFunc(300*time.Millisecond, func() { held.Unlock() })
+	waited, err := c.Lock(ctx, "run", &LockOptions{Wait: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Lock waiting for Unlock = %v", err)
+	}
+	defer waited.Unlock()
+
+	if held.Context().Err() == nil {
+		t.Error("context of the released lock is not cancelled")
+	}
+}
+
+func TestLockRenewal(t *testing.T) {
+	ctx := context.Background()
+	c := newLockCache(t)
+
+	lock, err := c.Lock(ctx, "run", &LockOptions{TTL: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Unlock()
+
+	// held for several TTLs
+	time.Sleep(time.Second)
+
+	if err := lock.Context().Err(); err != nil {
+		t.Errorf("lock lost while renewed: %v", err)
+	}
+	if _, err := c.Lock(ctx, "run", nil); !errors.Is(err, ErrLocked) {
+		t.Errorf("Lock of the renewed lease = %v, want ErrLocked", err)
+	}
+}
+
+// a holder that stopped renewing, e.g. because its process died
+func TestLockReleasedAfterExpiry(t *testing.T) {
+	ctx := context.Background()
+	c := newLockCache(t)
+
+	if _, err := c.locker().Acquire(ctx, c.key("lock:run"), "crashed", 300*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Lock(ctx, "run", nil); !errors.Is(err, ErrLocked) {
+		t.Errorf("Lock before the lease expired = %v, want ErrLocked", err)
+	}
+
+	lock, err := c.Lock(ctx, "run", &LockOptions{Wait: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Lock after the lease expired = %v", err)
+	}
+	lock.Unlock()
+}
+
+func TestLockLost(t *testing.T) {
+	ctx := context.Background()
+	c := newLockCache(t)
+
+	lock, err := c.Lock(ctx, "run", &LockOptions{TTL: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another owner takes over the lease
+	if err := c.locker().Release(ctx, lock.key, lock.owner); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.locker().Acquire(ctx, lock.key, "other", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-lock.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock context not cancelled after losing the lease")
+	}
+
+	// the other owner's lease is left alone
+	lock.Unlock()
+	if _, err := c.Lock(ctx, "run", nil); !errors.Is(err, ErrLocked) {
+		t.Errorf("Lock after Unlock of a lost lease = %v, want ErrLocked", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	c := newLockCache(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			ids := []string{}
+			err := c.Update(ctx, "ids", &ids, time.Hour, func() error {
+				ids = append(ids, id)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(fmt.Sprint(i))
+	}
+	wg.Wait()
+
+	ids := []string{}
+	if err := c.Get(ctx, "ids", &ids); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ids)
+	if want := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}; !slices.Equal(ids, want) {
+		t.Errorf("ids = %v, want %v", ids, want)
+	}
+
+	failed := errors.New("failed")
+	if err := c.Update(ctx, "ids", &ids, time.Hour, func() error { return failed }); !errors.Is(err, failed) {
+		t.Errorf("Update = %v, want the error of update", err)
+	}
+}
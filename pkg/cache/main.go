@@ -2,22 +2,38 @@ package cache
 
 import (
 	"context"
+	"io"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/eu-evops/edulink/pkg/cache/common"
+	"github.com/eu-evops/edulink/pkg/cache/disk"
+	"github.com/eu-evops/edulink/pkg/cache/local"
 	"github.com/eu-evops/edulink/pkg/cache/redis"
 )
 
+// Cache stores values in one of the backends. Its keys are scoped by a
+// prefix, see WithPrefix, which every method applies before the backend sees
+// them.
 type Cache struct {
-	cache common.CacheInt
+	cache  common.CacheInt
+	prefix string
 }
 
 func New(options *common.CacheOptions) *Cache {
 	c := &Cache{}
+	if options.Namespace != "" {
+		c.prefix = options.Namespace + ":"
+	}
 
 	switch options.CacheType {
 	case common.Redis:
 		c.cache = redis.New(options)
+	case common.Local:
+		c.cache = local.New(options)
+	case common.Disk:
+		c.cache = disk.New(options)
 	default:
 		return nil
 	}
@@ -30,14 +46,108 @@ func (c *Cache) Initialise() error {
 	return c.cache.Initialise()
 }
 
+// WithPrefix returns a cache sharing the backend whose keys are scoped by
+// prefix, e.g. WithPrefix("acct:123") stores "key" as "acct:123:key". Prefixes
+// nest, and keys stay within the scope of the cache they were set through.
+func (c *Cache) WithPrefix(prefix string) *Cache {
+	return &Cache{
+		cache:  c.cache,
+		prefix: c.prefix + prefix + ":",
+	}
+}
+
+// Unscoped returns a cache sharing the backend without any prefix, e.g. to
+// reach the keys written before a namespace was set
+func (c *Cache) Unscoped() *Cache {
+	return &Cache{cache: c.cache}
+}
+
+// Prefix is prepended to every key, empty for an unscoped cache
+func (c *Cache) Prefix() string {
+	if c == nil {
+		return ""
+	}
+	return c.prefix
+}
+
+func (c *Cache) key(key string) string {
+	return c.Prefix() + key
+}
+
 func (c *Cache) Get(ctx context.Context, key string, value interface{}) error {
-	return c.cache.Get(ctx, key, value)
+	return c.cache.Get(ctx, c.key(key), value)
 }
 
 func (c *Cache) Set(item *common.Item) error {
-	return c.cache.Set(item)
+	scoped := *item
+	scoped.Key = c.key(item.Key)
+	return c.cache.Set(&scoped)
 }
 
 func (c *Cache) Exists(ctx context.Context, key string) bool {
-	return c.cache.Exists(ctx, key)
+	return c.cache.Exists(ctx, c.key(key))
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	return c.cache.Delete(ctx, c.key(key))
+}
+
+// TTL is the time left before key expires, zero when it never does. It
+// returns common.ErrCacheMiss when the key does not exist.
+func (c *Cache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.cache.TTL(ctx, c.key(key))
+}
+
+// Scan returns the keys starting with prefix, sorted and without the prefix
+// of the cache
+func (c *Cache) Scan(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := c.cache.Scan(ctx, c.key(prefix))
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], c.prefix)
+	}
+	return keys, err
+}
+
+// SetAdd adds members to the set at key in one step, so concurrent adds from
+// other processes are never lost. A ttl above zero expires the set that long
+// after the last add.
+func (c *Cache) SetAdd(ctx context.Context, key string, ttl time.Duration, members ...string) error {
+	return c.cache.SetAdd(ctx, c.key(key), ttl, members...)
+}
+
+func (c *Cache) SetContains(ctx context.Context, key string, member string) (bool, error) {
+	return c.cache.SetContains(ctx, c.key(key), member)
+}
+
+// SetMembers returns the members of the set at key sorted, none when it does
+// not exist
+func (c *Cache) SetMembers(ctx context.Context, key string) ([]string, error) {
+	return c.cache.SetMembers(ctx, c.key(key))
+}
+
+// saver is implemented by caches that only persist their changes when asked
+type saver interface {
+	Save() error
+}
+
+// Save persists the changes made so far, so a crash does not lose them. Only
+// the Local cache needs it, it writes its snapshot; the other backends store
+// every change as it is made.
+func (c *Cache) Save() error {
+	if c == nil {
+		return nil
+	}
+	if saver, ok := c.cache.(saver); ok {
+		return saver.Save()
+	}
+	return nil
+}
+
+// Close releases the cache, the Local cache saves its snapshot and the Disk
+// cache closes its database
+func (c *Cache) Close() error {
+	if closer, ok := c.cache.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/eu-evops/edulink/pkg/cache/common"
+)
+
+// Migrate copies every entry of from to to, keeping what is left of their
+// TTLs, and returns how many were copied. Only entries within the prefix of
+// from are copied, moved to the prefix of to. Leases are not copied. Both
+// caches must be initialised.
+//
+// from and to may share a backend, e.g. Migrate(ctx, c.Unscoped(), c) copies
+// the keys written before a namespace was set into it. Entries already within
+// the prefix of to are left alone then, see MigrateKeys to leave the entries
+// of other prefixes alone too.
+func Migrate(ctx context.Context, from *Cache, to *Cache) (int, error) {
+	return MigrateKeys(ctx, from, to, nil)
+}
+
+// MigrateKeys is Migrate copying only the keys, without the prefix of from,
+// keep returns true for. A nil keep copies every key.
+func MigrateKeys(ctx context.Context, from *Cache, to *Cache, keep func(key string) bool) (int, error) {
+	source, ok := from.cache.(common.RawInt)
+	if !ok {
+		return 0, errors.New("cache: source cannot be migrated from")
+	}
+
+	destination, ok := to.cache.(common.RawInt)
+	if !ok {
+		return 0, errors.New("cache: destination cannot be migrated to")
+	}
+
+	sameBackend := from.cache == to.cache
+
+	// within one backend the entries are written once they have all been
+	// read, so the copies are not read again
+	pending := []*common.RawEntry{}
+
+	copied := 0
+	err := source.Each(ctx, func(entry *common.RawEntry) error {
+		if !strings.HasPrefix(entry.Key, from.prefix) {
+			return nil
+		}
+		if sameBackend && to.prefix != "" && strings.HasPrefix(entry.Key, to.prefix) {
+			return nil
+		}
+
+		key := strings.TrimPrefix(entry.Key, from.prefix)
+		if strings.HasPrefix(key, "lock:") || (keep != nil && !keep(key)) {
+			return nil
+		}
+
+		scoped := *entry
+		scoped.Key = to.key(key)
+
+		if sameBackend {
+			pending = append(pending, &scoped)
+			return nil
+		}
+
+		if err := destination.SetRaw(ctx, &scoped); err != nil {
+			return fmt.Errorf("copying %s: %w", entry.Key, err)
+		}
+
+		copied++
+		return nil
+	})
+
+	for _, entry := range pending {
+		if err != nil {
+			break
+		}
+
+		if err = destination.SetRaw(ctx, entry); err != nil {
+			err = fmt.Errorf("copying %s: %w", entry.Key, err)
+		} else {
+			copied++
+		}
+	}
+
+	log.Printf("Migrated %d cache entries\n", copied)
+	return copied, err
+}
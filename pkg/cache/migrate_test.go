@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache/common"
+)
+
+func TestMigrateIntoNamespace(t *testing.T) {
+	ctx := context.Background()
+
+	c := New(&common.CacheOptions{
+		CacheType: common.Disk,
+		DiskPath:  filepath.Join(t.TempDir(), "cache.db"),
+		Namespace: "school",
+	})
+	if err := c.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	unscoped := c.Unscoped()
+	if err := unscoped.SetAdd(ctx, "set:seen", time.Hour, "a1", "a2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unscoped.Set(&common.Item{Ctx: ctx, Key: "outbox", Value: "queued"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := unscoped.Set(&common.Item{Ctx: ctx, Key: "lock:report", Value: "held"}); err != nil {
+		t.Fatal(err)
+	}
+	// already within the namespace, must not be copied into school:school:
+	if err := c.Set(&common.Item{Ctx: ctx, Key: "current", Value: "kept"}); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := Migrate(ctx, unscoped, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 2 {
+		t.Errorf("copied %d entries, want 2", copied)
+	}
+
+	keys, err := unscoped.Scan(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"lock:report", "outbox", "school:current", "school:outbox", "school:set:seen", "set:seen"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+
+	seen, err := c.SetMembers(ctx, "set:seen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(seen, []string{"a1", "a2"}) {
+		t.Errorf("seen %v, want [a1 a2]", seen)
+	}
+
+	ttl, err := c.TTL(ctx, "set:seen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > time.Hour {
+		t.Errorf("ttl %s, want what is left of an hour", ttl)
+	}
+}
+
+func TestMigrateLocalCache(t *testing.T) {
+	c := New(&common.CacheOptions{CacheType: common.Local})
+	if _, err := Migrate(context.Background(), c.Unscoped(), c); err == nil {
+		t.Error("migrated from a cache without raw access")
+	}
+}
+
+// keys of other namespaces sharing the backend are left where they are
+func TestMigrateKeys(t *testing.T) {
+	ctx := context.Background()
+
+	c := New(&common.CacheOptions{
+		CacheType: common.Disk,
+		DiskPath:  filepath.Join(t.TempDir(), "cache.db"),
+		Namespace: "school",
+	})
+	if err := c.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	unscoped := c.Unscoped()
+	for _, key := range []string{"outbox", "other:outbox"} {
+		if err := unscoped.Set(&common.Item{Ctx: ctx, Key: key, Value: "queued"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	copied, err := MigrateKeys(ctx, unscoped, c, func(key string) bool {
+		return key == "outbox"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 1 {
+		t.Errorf("copied %d entries, want 1", copied)
+	}
+
+	keys, err := unscoped.Scan(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"other:outbox", "outbox", "school:outbox"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+}
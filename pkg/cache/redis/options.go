@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/eu-evops/edulink/pkg/cache/common"
+	"github.com/go-redis/redis/v9"
+)
+
+// DefaultPort is used when neither RedisPort nor RedisURL give one
+const DefaultPort = 6379
+
+// newClient connects in one of three ways: RedisURL, RedisHost and RedisPort,
+// or through the Sentinels in RedisSentinelAddrs. Settings that are given
+// explicitly override those from the URL.
+func newClient(o *common.CacheOptions) (*redis.Client, error) {
+	if err := validate(o); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := tlsConfig(o)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(o.RedisSentinelAddrs) > 0 {
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       o.RedisSentinelMaster,
+			SentinelAddrs:    o.RedisSentinelAddrs,
+			SentinelPassword: o.RedisSentinelPassword,
+			Username:         o.RedisUsername,
+			Password:         o.RedisPassword,
+			DB:               db(o, 0),
+			TLSConfig:        tlsConfig,
+		}), nil
+	}
+
+	options := &redis.Options{}
+	if o.RedisURL != "" {
+		if options, err = redis.ParseURL(o.RedisURL); err != nil {
+			return nil, fmt.Errorf("redis: invalid RedisURL: %w", err)
+		}
+	} else {
+		port := o.RedisPort
+		if port == 0 {
+			port = DefaultPort
+		}
+		options.Addr = net.JoinHostPort(o.RedisHost, strconv.Itoa(port))
+	}
+
+	if o.RedisUsername != "" {
+		options.Username = o.RedisUsername
+	}
+	if o.RedisPassword != "" {
+		options.Password = o.RedisPassword
+	}
+	options.DB = db(o, options.DB)
+	if tlsConfig != nil {
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(options.Addr)
+		}
+		options.TLSConfig = tlsConfig
+	}
+
+	return redis.NewClient(options), nil
+}
+
+// db is RedisDB when it is set, otherwise fallback
+func db(o *common.CacheOptions, fallback int) int {
+	if o.RedisDB != nil {
+		return *o.RedisDB
+	}
+	return fallback
+}
+
+func validate(o *common.CacheOptions) error {
+	ways := 0
+	for _, given := range []bool{o.RedisURL != "", o.RedisHost != "", len(o.RedisSentinelAddrs) > 0} {
+		if given {
+			ways++
+		}
+	}
+
+	switch {
+	case ways == 0:
+		return errors.New("redis: set one of RedisURL, RedisHost or RedisSentinelAddrs")
+	case ways > 1:
+		return errors.New("redis: RedisURL, RedisHost and RedisSentinelAddrs cannot be combined")
+	case o.RedisPort < 0 || o.RedisPort > 65535:
+		return fmt.Errorf("redis: RedisPort %d is not a port", o.RedisPort)
+	case o.RedisPort != 0 && o.RedisHost == "":
+		return errors.New("redis: RedisPort needs RedisHost, put the port in RedisURL or RedisSentinelAddrs")
+	case o.RedisDB != nil && *o.RedisDB < 0:
+		return fmt.Errorf("redis: RedisDB %d cannot be negative", *o.RedisDB)
+	case len(o.RedisSentinelAddrs) > 0 && o.RedisSentinelMaster == "":
+		return errors.New("redis: RedisSentinelAddrs needs RedisSentinelMaster")
+	case len(o.RedisSentinelAddrs) == 0 && o.RedisSentinelMaster != "":
+		return errors.New("redis: RedisSentinelMaster needs RedisSentinelAddrs")
+	}
+
+	for _, addr := range o.RedisSentinelAddrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("redis: Sentinel address %q should be host:port", addr)
+		}
+	}
+
+	return nil
+}
+
+// tlsConfig returns nil unless TLS is asked for, by RedisTLS or one of the
+// settings that only make sense with it. rediss:// URLs turn TLS on by themselves.
+func tlsConfig(o *common.CacheOptions) (*tls.Config, error) {
+	if !o.RedisTLS && o.RedisTLSCAFile == "" && o.RedisTLSServerName == "" && !o.RedisTLSInsecureSkipVerify {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.RedisTLSServerName,
+		InsecureSkipVerify: o.RedisTLSInsecureSkipVerify,
+	}
+
+	if o.RedisTLSCAFile != "" {
+		pem, err := os.ReadFile(o.RedisTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis: reading RedisTLSCAFile: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("redis: no PEM certificates in %s", o.RedisTLSCAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
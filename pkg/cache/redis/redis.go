@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/eu-evops/edulink/pkg/cache/common"
@@ -12,7 +15,8 @@ import (
 )
 
 type RedisCache struct {
-	cache *cachev9.Cache
+	cache  *cachev9.Cache
+	client *redis.Client
 
 	options *common.CacheOptions
 }
@@ -25,11 +29,11 @@ func New(options *common.CacheOptions) *RedisCache {
 
 func (c *RedisCache) Initialise() error {
 	log.Println("Initialising cache")
-	redis := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:6379", c.options.RedisHost),
-		Username: c.options.RedisUsername,
-		Password: c.options.RedisPassword,
-	})
+	redis, err := newClient(c.options)
+	if err != nil {
+		return err
+	}
+	c.client = redis
 	c.cache = cachev9.New(&cachev9.Options{
 		Redis:        redis,
 		LocalCache:   cachev9.NewTinyLFU(1000, time.Minute),
@@ -37,9 +41,19 @@ func (c *RedisCache) Initialise() error {
 	})
 
 	var ret string
-	err := c.Get(context.Background(), "randomKey", &ret)
+	err = c.Get(context.Background(), "randomKey", &ret)
 	if err != nil && err != cachev9.ErrCacheMiss {
-		return err
+		return fmt.Errorf("redis: connecting to %s: %w", redis.Options().Addr, err)
+	}
+
+	// keys of one cache span slots, which Redis Cluster cannot scan or
+	// update together
+	info, err := redis.Info(context.Background(), "cluster").Result()
+	if err != nil {
+		return fmt.Errorf("redis: reading the cluster info of %s: %w", redis.Options().Addr, err)
+	}
+	if strings.Contains(info, "cluster_enabled:1") {
+		return fmt.Errorf("redis: %s is a Redis Cluster, which is not supported, connect to a single server or through Sentinel", redis.Options().Addr)
 	}
 
 	return nil
@@ -47,7 +61,7 @@ func (c *RedisCache) Initialise() error {
 
 func (cd *RedisCache) Get(ctx context.Context, key string, value interface{}) error {
 	log.Printf("Getting key %s from cache %+v", key, cd)
-	return cd.cache.Get(ctx, key, value)
+	return wrongType(cd.cache.Get(ctx, key, value))
 }
 
 func cachev9Version(i *common.Item) *cachev9.Item {
@@ -64,5 +78,200 @@ func (c *RedisCache) Set(item *common.Item) error {
 }
 
 func (c *RedisCache) Exists(ctx context.Context, key string) bool {
-	return c.cache.Exists(ctx, key)
+	if c.cache.Exists(ctx, key) {
+		return true
+	}
+
+	// sets are not read through the value cache
+	exists, err := c.client.Exists(ctx, key).Result()
+	return err == nil && exists > 0
+}
+
+// GetSkippingLocalCache reads the value from Redis, it may have been written
+// by another process since it was cached locally
+func (c *RedisCache) GetSkippingLocalCache(ctx context.Context, key string, value interface{}) error {
+	return wrongType(c.cache.GetSkippingLocalCache(ctx, key, value))
+}
+
+// leases only change while their owner holds them
+var (
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+func (c *RedisCache) Acquire(ctx context.Context, key string, owner string, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, key, owner, ttl).Result()
+}
+
+func (c *RedisCache) Renew(ctx context.Context, key string, owner string, ttl time.Duration) (bool, error) {
+	renewed, err := renewScript.Run(ctx, c.client, []string{key}, owner, ttl.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+func (c *RedisCache) Release(ctx context.Context, key string, owner string) error {
+	return releaseScript.Run(ctx, c.client, []string{key}, owner).Err()
+}
+
+// Each calls fn with every value and set in the database, as stored
+func (c *RedisCache) Each(ctx context.Context, fn func(entry *common.RawEntry) error) error {
+	iter := c.client.Scan(ctx, 0, "*", 100).Iterator()
+	for iter.Next(ctx) {
+		entry := &common.RawEntry{Key: iter.Val()}
+
+		kind, err := c.client.Type(ctx, entry.Key).Result()
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case "string":
+			entry.Data, err = c.client.Get(ctx, entry.Key).Bytes()
+		case "set":
+			entry.IsSet = true
+			entry.Members, err = c.client.SMembers(ctx, entry.Key).Result()
+		case "none":
+			// expired since the scan
+			continue
+		default:
+			log.Printf("Skipping %s, a Redis %s\n", entry.Key, kind)
+			continue
+		}
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if entry.TTL, err = c.ttl(ctx, entry.Key); err == common.ErrCacheMiss {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return iter.Err()
+}
+
+func (c *RedisCache) SetRaw(ctx context.Context, entry *common.RawEntry) error {
+	c.cache.DeleteFromLocalCache(entry.Key)
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if !entry.IsSet {
+			pipe.Set(ctx, entry.Key, entry.Data, entry.TTL)
+			return nil
+		}
+
+		pipe.Del(ctx, entry.Key)
+		if len(entry.Members) > 0 {
+			pipe.SAdd(ctx, entry.Key, members(entry.Members)...)
+			if entry.TTL > 0 {
+				pipe.PExpire(ctx, entry.Key, entry.TTL)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	return c.cache.Delete(ctx, key)
+}
+
+func (c *RedisCache) ttl(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := c.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	switch ttl {
+	case -2:
+		return 0, common.ErrCacheMiss
+	case -1:
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (c *RedisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.ttl(ctx, key)
+}
+
+// globChars are special in SCAN patterns
+var globChars = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func (c *RedisCache) Scan(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+
+	iter := c.client.Scan(ctx, 0, globChars.Replace(prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	// SCAN can return a key more than once
+	sort.Strings(keys)
+	return slices.Compact(keys), nil
+}
+
+func members(list []string) []interface{} {
+	values := make([]interface{}, len(list))
+	for i, member := range list {
+		values[i] = member
+	}
+	return values
+}
+
+// wrongType turns the Redis WRONGTYPE error into common.ErrWrongType
+func wrongType(err error) error {
+	if err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		return common.ErrWrongType
+	}
+	return err
+}
+
+func (c *RedisCache) SetAdd(ctx context.Context, key string, ttl time.Duration, added ...string) error {
+	// like Redis, there are no empty sets
+	if len(added) == 0 {
+		return nil
+	}
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, key, members(added)...)
+		if ttl > 0 {
+			pipe.PExpire(ctx, key, ttl)
+		}
+		return nil
+	})
+	return wrongType(err)
+}
+
+func (c *RedisCache) SetContains(ctx context.Context, key string, member string) (bool, error) {
+	contains, err := c.client.SIsMember(ctx, key, member).Result()
+	return contains, wrongType(err)
+}
+
+func (c *RedisCache) SetMembers(ctx context.Context, key string) ([]string, error) {
+	list, err := c.client.SMembers(ctx, key).Result()
+	if err != nil {
+		return []string{}, wrongType(err)
+	}
+
+	sort.Strings(list)
+	return list, nil
 }
@@ -0,0 +1,128 @@
+package edulink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type CacheableRequest struct {
+	ApiMethod string        `json:"api_method"`
+	TTL       time.Duration `json:"ttl"`
+}
+
+var DefaultCacheableRequests = []CacheableRequest{
+	{
+		ApiMethod: "EduLink.SchoolDetails",
+		TTL:       24 * time.Hour,
+	},
+	{
+		ApiMethod: "EduLink.AchievementBehaviourLookups",
+		TTL:       24 * time.Hour,
+	},
+}
+
+// ParseCacheableRequests parses a comma separated list of method=ttl pairs,
+// e.g. "EduLink.LearnerPhotos=168h,EduLink.SchoolDetails=24h"
+func ParseCacheableRequests(value string) ([]CacheableRequest, error) {
+	cacheableRequests := []CacheableRequest{}
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		apiMethod, ttl, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid cacheable request %q, expected method=ttl", pair)
+		}
+
+		duration, err := time.ParseDuration(strings.TrimSpace(ttl))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl for %s: %w", apiMethod, err)
+		}
+
+		cacheableRequests = append(cacheableRequests, CacheableRequest{
+			ApiMethod: strings.TrimSpace(apiMethod),
+			TTL:       duration,
+		})
+	}
+
+	return cacheableRequests, nil
+}
+
+// SetCacheableRequests replaces the list of cached methods, it is safe to call
+// while the client is in use
+func (c *Client) SetCacheableRequests(cacheableRequests []CacheableRequest) {
+	c.cacheableMu.Lock()
+	defer c.cacheableMu.Unlock()
+
+	c.cacheableRequests = append([]CacheableRequest{}, cacheableRequests...)
+}
+
+func (c *Client) CacheableRequests() []CacheableRequest {
+	c.cacheableMu.RLock()
+	defer c.cacheableMu.RUnlock()
+
+	return append([]CacheableRequest{}, c.cacheableRequests...)
+}
+
+func (c *Client) cacheTTL(apiMethod string) (time.Duration, bool) {
+	c.cacheableMu.RLock()
+	defer c.cacheableMu.RUnlock()
+
+	for _, cacheableRequest := range c.cacheableRequests {
+		if cacheableRequest.ApiMethod == apiMethod && cacheableRequest.TTL > 0 {
+			return cacheableRequest.TTL, true
+		}
+	}
+	return 0, false
+}
+
+type accountContextKey struct{}
+
+// WithAccount tags calls made with the context with the account they are made
+// for, so cached responses are never shared between accounts
+func WithAccount(ctx context.Context, account string) context.Context {
+	return context.WithValue(ctx, accountContextKey{}, account)
+}
+
+func accountFromContext(ctx context.Context) string {
+	account, _ := ctx.Value(accountContextKey{}).(string)
+	return account
+}
+
+// cacheKey derives the cache key for a request from its method, its params in
+// canonical form, the school and the account it is made for
+func (c *Client) cacheKey(ctx context.Context, body Request) (string, error) {
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	var request struct {
+		Params interface{} `json:"params"`
+	}
+	if err := json.Unmarshal(bodyBytes, &request); err != nil {
+		return "", err
+	}
+
+	// maps are marshalled with sorted keys, so equal params give equal bytes
+	params, err := json.Marshal(request.Params)
+	if err != nil {
+		return "", err
+	}
+
+	account := ""
+	if body.GetBaseRequest().AuthToken != "" {
+		account = strings.ToLower(accountFromContext(ctx))
+	}
+
+	hash := sha256.Sum256(params)
+	return fmt.Sprintf("%s:%s:%s:%s", body.GetBaseRequest().Method, c.school.Code, account, hex.EncodeToString(hash[:8])), nil
+}
@@ -0,0 +1,90 @@
+package edulink_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/edulink/edulinktest"
+)
+
+// cachingSession is a session whose client caches EduLink.Achievement
+// responses in c
+func cachingSession(t *testing.T, server *edulinktest.Server, c *cache.Cache, username string) *edulink.Session {
+	t.Helper()
+
+	options := server.ClientOptions()
+	options.Retry = testRetry
+	options.Cache = c
+	options.CacheableRequests = []edulink.CacheableRequest{
+		{ApiMethod: "EduLink.Achievement", TTL: time.Hour},
+		{ApiMethod: "EduLink.SchoolDetails", TTL: time.Hour},
+	}
+
+	client, err := edulink.NewClient(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return edulink.NewSession(&edulink.SessionOptions{Client: client, Username: username, Password: "password"})
+}
+
+func TestCachedResponsesAreNotShared(t *testing.T) {
+	tests := []struct {
+		name string
+		// the first calls are made by parent at the first school, the
+		// second by secondUsername at secondSchool
+		secondSchool   int
+		secondUsername string
+		// wantCalls are the calls each school answered, second calls
+		// answered from the cache are not among them
+		wantCalls       []int
+		wantSchoolCalls []int
+	}{
+		{name: "same account", secondUsername: "parent", wantCalls: []int{1, 0}, wantSchoolCalls: []int{1, 0}},
+		{name: "two accounts", secondUsername: "other", wantCalls: []int{2, 0}, wantSchoolCalls: []int{1, 0}},
+		{name: "two schools", secondSchool: 1, secondUsername: "parent", wantCalls: []int{1, 1}, wantSchoolCalls: []int{1, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			servers := make([]*edulinktest.Server, 2)
+			for i, code := range []string{"edulinktest", "otherschool"} {
+				fixtures := edulinktest.DefaultFixtures()
+				fixtures.SchoolCode = code
+				other := fixtures.Accounts[0]
+				other.Username = "other"
+				fixtures.Accounts = append(fixtures.Accounts, other)
+
+				servers[i] = edulinktest.NewServer(fixtures)
+				defer servers[i].Close()
+			}
+
+			c := newTestCache(t)
+			sessions := []*edulink.Session{
+				cachingSession(t, servers[0], c, "parent"),
+				cachingSession(t, servers[test.secondSchool], c, test.secondUsername),
+			}
+			for _, session := range sessions {
+				if err := achievement(ctx, session); err != nil {
+					t.Fatal(err)
+				}
+				if err := schoolDetails(ctx, session.Client()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i, server := range servers {
+				if got := server.Calls("EduLink.Achievement"); got != test.wantCalls[i] {
+					t.Errorf("school %d: EduLink.Achievement called %d times, want %d", i+1, got, test.wantCalls[i])
+				}
+				if got := server.Calls("EduLink.SchoolDetails"); got != test.wantSchoolCalls[i] {
+					t.Errorf("school %d: EduLink.SchoolDetails called %d times, want %d", i+1, got, test.wantSchoolCalls[i])
+				}
+			}
+		})
+	}
+}
@@ -4,60 +4,207 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/eu-evops/edulink/pkg/cache"
 	"github.com/eu-evops/edulink/pkg/cache/common"
 )
 
-type CacheableRequest struct {
-	ApiMethod string        `json:"api_method"`
-	TTL       time.Duration `json:"ttl"`
+// classifyFailure maps a failed call onto one of the sentinel errors, based
+// on the HTTP status code and the error message returned by EduLink.
+func classifyFailure(apiMethod string, statusCode int, message string) error {
+	message = strings.ToLower(message)
+
+	switch {
+	case statusCode == http.StatusTooManyRequests,
+		strings.Contains(message, "rate limit"),
+		strings.Contains(message, "too many"):
+		return ErrRateLimited
+	case statusCode >= 500:
+		return nil
+	case apiMethod == "EduLink.Login":
+		return ErrAuthentication
+	case statusCode == http.StatusUnauthorized,
+		statusCode == http.StatusForbidden,
+		strings.Contains(message, "session"),
+		strings.Contains(message, "token"):
+		return ErrSessionExpired
+	}
+
+	return nil
 }
 
-var (
-	Cache             *cache.Cache
-	CacheableRequests = []CacheableRequest{
-		{
-			ApiMethod: "EduLink.SchoolDetails",
-			TTL:       24 * time.Hour,
-		},
-		{
-			ApiMethod: "EduLink.AchievementBehaviourLookups",
-			TTL:       24 * time.Hour,
-		},
-	}
+const (
+	DefaultRateLimit = 5
+	DefaultRateBurst = 10
 )
 
-func isCacheableRequest(apiMethod string) bool {
-	for _, cacheableRequest := range CacheableRequests {
-		if cacheableRequest.ApiMethod == apiMethod {
-			return true
+// Client talks to the EduLink JSON-RPC endpoint of one school. Several
+// clients can live side by side in one process.
+type Client struct {
+	school School
+
+	httpClient  *http.Client
+	cache       *cache.Cache
+	logger      *log.Logger
+	retry       RetryPolicy
+	rateLimiter *RateLimiter
+
+	cacheableMu       sync.RWMutex
+	cacheableRequests []CacheableRequest
+}
+
+type ClientOptions struct {
+	// School is the school the client talks to, see SchoolResolver
+	School *School
+
+	// HTTPClient is used for all API calls, defaults to a client with a 10 second timeout
+	HTTPClient *http.Client
+
+	// Cache stores cacheable responses, caching is disabled when nil
+	Cache *cache.Cache
+
+	// Logger defaults to the standard logger
+	Logger *log.Logger
+
+	// Retry controls retries of failed calls, defaults to DefaultRetryPolicy.
+	// Set MaxAttempts to 1 to disable retries.
+	Retry *RetryPolicy
+
+	// RateLimiter limits the calls sent to EduLink, pass the same one to
+	// every client to limit them together. Defaults to a limiter of the
+	// client's own, allowing DefaultRateLimit calls per second with bursts of
+	// DefaultRateBurst.
+	RateLimiter *RateLimiter
+
+	// CacheableRequests lists the methods whose responses are cached and for
+	// how long, defaults to DefaultCacheableRequests
+	CacheableRequests []CacheableRequest
+}
+
+// NewClient returns an error when o has no school to talk to
+func NewClient(o *ClientOptions) (*Client, error) {
+	if o.School == nil || o.School.Endpoint == "" {
+		return nil, errors.New("edulink: the client needs a school with an endpoint")
+	}
+
+	c := &Client{
+		school:      *o.School,
+		httpClient:  o.HTTPClient,
+		cache:       o.Cache,
+		logger:      o.Logger,
+		retry:       DefaultRetryPolicy,
+		rateLimiter: o.RateLimiter,
+	}
+
+	cacheableRequests := o.CacheableRequests
+	if cacheableRequests == nil {
+		cacheableRequests = DefaultCacheableRequests
+	}
+	c.SetCacheableRequests(cacheableRequests)
+
+	if o.Retry != nil {
+		c.retry = *o.Retry
+	}
+
+	if c.rateLimiter == nil {
+		c.rateLimiter = NewRateLimiter(DefaultRateLimit, DefaultRateBurst)
+	}
+
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{
+			Transport: &http.Transport{},
+			Timeout:   10 * time.Second,
 		}
 	}
-	return false
+
+	if c.logger == nil {
+		c.logger = log.Default()
+	}
+
+	return c, nil
+}
+
+func (c *Client) School() School {
+	return c.school
+}
+
+func (c *Client) EstablishmentID() int {
+	return c.school.EstablishmentID
+}
+
+func (c *Client) Cache() *cache.Cache {
+	return c.cache
 }
 
-func Call(ctx context.Context, body Request, response Result) error {
+func (c *Client) Call(ctx context.Context, body Request, response Result) error {
 	apiMethod := body.GetBaseRequest().Method
+	ttl, cacheable := c.cacheTTL(apiMethod)
+	cacheable = cacheable && c.cache != nil
+
+	var key string
+	if cacheable {
+		var err error
+		if key, err = c.cacheKey(ctx, body); err != nil {
+			return err
+		}
 
-	if isCacheableRequest(apiMethod) {
-		log.Printf("Request cachable: '%s', checking cache\n", apiMethod)
-		if Cache.Exists(ctx, apiMethod) {
-			log.Printf("Found in cache: '%s', returning\n", apiMethod)
-			return Cache.Get(ctx, apiMethod, response)
+		c.logger.Printf("Request cachable: '%s', checking cache\n", key)
+		if c.cache.Exists(ctx, key) {
+			c.logger.Printf("Found in cache: '%s', returning\n", key)
+			return c.cache.Get(ctx, key, response)
 		}
 
-		log.Printf("Request not cached, calling API: '%s'\n", apiMethod)
+		c.logger.Printf("Request not cached, calling API: '%s'\n", apiMethod)
+	}
+
+	err := c.retry.do(ctx, c.logger, apiMethod, func() error {
+		return c.do(ctx, body, response)
+	})
+	if err != nil {
+		return err
+	}
+
+	if cacheable {
+		c.logger.Printf("Caching response: '%s' for %s\n", key, ttl)
+		c.cache.Set(&common.Item{
+			Ctx:   ctx,
+			Key:   key,
+			Value: response,
+			TTL:   ttl,
+
+			// responses are fetched again when evicted
+			Evictable: true,
+		})
+	}
+
+	return nil
+}
+
+// do sends a single request to EduLink, waiting for the rate limiter first
+func (c *Client) do(ctx context.Context, body Request, response Result) error {
+	apiMethod := body.GetBaseRequest().Method
+
+	if err := c.rateLimiter.Wait(ctx); err != nil {
+		return err
 	}
 
-	bodyBytes, _ := json.Marshal(body)
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
 
-	req, _ := http.NewRequestWithContext(ctx, "POST", API_ENDPOINT, bytes.NewBuffer(bodyBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.school.Endpoint, bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return err
+	}
 
 	req.Header.Set("content-type", "application/json")
 	req.Header.Set("x-api-method", apiMethod)
@@ -66,34 +213,41 @@ func Call(ctx context.Context, body Request, response Result) error {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", body.GetBaseRequest().AuthToken))
 	}
 
-	client := &http.Client{
-		Transport: &http.Transport{},
-		Timeout:   10 * time.Second,
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return &TransportError{Method: apiMethod, Err: err}
 	}
-	resp, err := client.Do(req)
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		panic(err)
+		return &TransportError{Method: apiMethod, Err: err}
 	}
 
-	respBody, _ := io.ReadAll(resp.Body)
-	json.Unmarshal(respBody, response)
+	if err := json.Unmarshal(respBody, response); err != nil {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return &APIError{
+				Method:     apiMethod,
+				Message:    http.StatusText(resp.StatusCode),
+				StatusCode: resp.StatusCode,
+				Kind:       classifyFailure(apiMethod, resp.StatusCode, ""),
+			}
+		}
 
-	if !response.GetBaseResult().Success {
-		parsedJSON, _ := json.MarshalIndent(response, "", "  ")
-		log.Printf("Response body: %s\n", respBody)
-		log.Printf("Parsed JSON: %s\n", parsedJSON)
-		log.Println()
-		return fmt.Errorf("API call failed: %s", apiMethod)
+		return &TransportError{Method: apiMethod, Err: fmt.Errorf("decoding response: %w", err)}
 	}
 
-	if isCacheableRequest(apiMethod) {
-		log.Printf("Caching response: '%s'\n", apiMethod)
-		Cache.Set(&common.Item{
-			Ctx:   ctx,
-			Key:   apiMethod,
-			Value: response,
-			TTL:   24 * time.Hour,
-		})
+	if result := response.GetBaseResult(); !result.Success {
+		c.logger.Printf("Response body: %s\n", respBody)
+		c.logger.Println()
+
+		return &APIError{
+			Method:     apiMethod,
+			Message:    result.Error,
+			UniqueID:   result.Metrics.UniqueID,
+			StatusCode: resp.StatusCode,
+			Kind:       classifyFailure(apiMethod, resp.StatusCode, result.Error),
+		}
 	}
 
 	return nil
@@ -0,0 +1,123 @@
+package edulink_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/edulink/edulinktest"
+)
+
+func newTestClient(t *testing.T, server *edulinktest.Server) *edulink.Client {
+	t.Helper()
+
+	options := server.ClientOptions()
+	options.Retry = testRetry
+	options.Logger = log.New(io.Discard, "", 0)
+
+	client, err := edulink.NewClient(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func schoolDetails(ctx context.Context, client *edulink.Client) error {
+	var response edulink.SchoolDetailsResponse
+	return client.Call(ctx, edulink.SchoolDetailsRequest{
+		RequestBase: edulink.RequestBase{ID: 1, JsonRPC: "2.0", Method: "EduLink.SchoolDetails"},
+		Params:      edulink.SchoolDetailsRequestParams{EstablishmentID: client.EstablishmentID()},
+	}, &response)
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []edulinktest.Failure
+		// wantErr is the sentinel the error matches, nil when the call succeeds
+		wantErr   error
+		wantAPI   bool
+		wantCalls int
+	}{
+		{name: "no failure", wantCalls: 1},
+		{
+			name:      "server errors are retried",
+			failures:  []edulinktest.Failure{{StatusCode: http.StatusServiceUnavailable}, {StatusCode: http.StatusInternalServerError}},
+			wantCalls: 3,
+		},
+		{
+			name:      "dropped connections are retried",
+			failures:  []edulinktest.Failure{{Drop: true}},
+			wantCalls: 2,
+		},
+		{
+			name:      "rate limit message is retried",
+			failures:  []edulinktest.Failure{{Message: "Too many requests, slow down"}},
+			wantCalls: 2,
+		},
+		{
+			name:      "gives up after the last attempt",
+			failures:  []edulinktest.Failure{{StatusCode: http.StatusTooManyRequests}, {StatusCode: http.StatusTooManyRequests}, {StatusCode: http.StatusTooManyRequests}},
+			wantErr:   edulink.ErrRateLimited,
+			wantCalls: 3,
+		},
+		{
+			name:      "transport errors are retried until the last attempt",
+			failures:  []edulinktest.Failure{{Drop: true}, {Drop: true}, {Drop: true}},
+			wantErr:   edulink.ErrTransport,
+			wantCalls: 3,
+		},
+		{
+			name:      "expired tokens are left to the session",
+			failures:  []edulinktest.Failure{{StatusCode: http.StatusUnauthorized}},
+			wantErr:   edulink.ErrSessionExpired,
+			wantCalls: 1,
+		},
+		{
+			name:      "session message is an expired token",
+			failures:  []edulinktest.Failure{{Message: "The session has expired or is invalid"}},
+			wantErr:   edulink.ErrAuthentication,
+			wantCalls: 1,
+		},
+		{
+			name:      "other failures are not retried",
+			failures:  []edulinktest.Failure{{Message: "Learner not found"}},
+			wantAPI:   true,
+			wantCalls: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := edulinktest.NewServer(nil)
+			defer server.Close()
+
+			for _, failure := range test.failures {
+				server.Fail("EduLink.SchoolDetails", failure, 1)
+			}
+
+			err := schoolDetails(context.Background(), newTestClient(t, server))
+			switch {
+			case test.wantErr != nil:
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("got %v, want %v", err, test.wantErr)
+				}
+			case test.wantAPI:
+				var apiErr *edulink.APIError
+				if !errors.As(err, &apiErr) || apiErr.Kind != nil {
+					t.Errorf("got %v, want an unclassified API error", err)
+				}
+			case err != nil:
+				t.Errorf("got %v, want no error", err)
+			}
+
+			if got := server.Calls("EduLink.SchoolDetails"); got != test.wantCalls {
+				t.Errorf("called %d times, want %d", got, test.wantCalls)
+			}
+		})
+	}
+}
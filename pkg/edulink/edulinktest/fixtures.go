@@ -0,0 +1,119 @@
+package edulinktest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+)
+
+// Account is a parent account the fake server accepts
+type Account struct {
+	Username string
+	Password string
+	Forename string
+	Surname  string
+	Children []edulink.Child
+}
+
+// Fixtures is the data served by the fake server, keyed the same way EduLink
+// keys it: behaviour and achievements by learner ID, photos by learner or
+// employee ID.
+type Fixtures struct {
+	SchoolCode      string
+	EstablishmentID int
+	Establishment   edulink.Establishment
+	Accounts        []Account
+
+	AchievementTypes []edulink.AchievementType
+	BehaviourTypes   []edulink.BehaviourType
+
+	Employees   []edulink.Employee
+	Behaviour   map[string][]edulink.Behaviour
+	Achievement map[string][]edulink.Achievement
+
+	LearnerPhotos map[string]string
+	TeacherPhotos map[string]string
+}
+
+// Photo returns a base64 encoded PNG filled with the given colour
+func Photo(c color.Color) string {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			img.Set(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func daysAgo(days int) edulink.DateOnly {
+	now := time.Now()
+	return edulink.DateOnly(time.Date(now.Year(), now.Month(), now.Day()-days, 0, 0, 0, 0, time.UTC))
+}
+
+// DefaultFixtures returns one account with two children and a handful of
+// recent achievements and behaviours
+func DefaultFixtures() *Fixtures {
+	return &Fixtures{
+		SchoolCode:      "edulinktest",
+		EstablishmentID: 2,
+		Establishment: edulink.Establishment{
+			Name: "EduLink Test School",
+			Logo: Photo(color.RGBA{R: 30, G: 60, B: 140, A: 255}),
+		},
+		Accounts: []Account{
+			{
+				Username: "parent",
+				Password: "password",
+				Forename: "Pat",
+				Surname:  "Parent",
+				Children: []edulink.Child{
+					{ID: "1001", Forename: "Sam", Surname: "Parent", Gender: "M", YearGroupID: "7"},
+					{ID: "1002", Forename: "Alex", Surname: "Parent", Gender: "F", YearGroupID: "9"},
+				},
+			},
+		},
+		AchievementTypes: []edulink.AchievementType{
+			{ID: "1", Active: true, Code: "EFF", Description: "Excellent effort", Points: 2},
+			{ID: "2", Active: true, Code: "HW", Description: "Outstanding homework", Points: 3},
+		},
+		BehaviourTypes: []edulink.BehaviourType{
+			{AchievementType: edulink.AchievementType{ID: "1", Active: true, Code: "LATE", Description: "Late to lesson", Points: -1}},
+			{AchievementType: edulink.AchievementType{ID: "2", Active: true, Code: "EQ", Description: "No equipment", Points: -1}},
+		},
+		Employees: []edulink.Employee{
+			{ID: "501", Title: "Mrs", Forename: "Jane", Surname: "Smith", Gender: "F"},
+			{ID: "502", Title: "Mr", Forename: "John", Surname: "Jones", Gender: "M"},
+		},
+		Achievement: map[string][]edulink.Achievement{
+			"1001": {
+				{ID: "a1", TypeIDs: []string{"1"}, Date: daysAgo(1), Points: 2, Comments: "Great work in maths", LessonInformation: "Maths", InvolvedEmployeeIDs: []string{"501"}},
+				{ID: "a2", TypeIDs: []string{"2"}, Date: daysAgo(3), Points: 3, InvolvedEmployeeIDs: []string{"502"}},
+			},
+			"1002": {
+				{ID: "a3", TypeIDs: []string{"1"}, Date: daysAgo(2), Points: 2, Comments: "Helped a classmate", InvolvedEmployeeIDs: []string{"502"}},
+			},
+		},
+		Behaviour: map[string][]edulink.Behaviour{
+			"1001": {
+				{ID: "b1", TypeIDs: []string{"1"}, Date: daysAgo(2), Points: -1, LessonInformation: "Science", InvolvedEmployeeIDs: []string{"501"}},
+			},
+		},
+		LearnerPhotos: map[string]string{
+			"1001": Photo(color.RGBA{R: 200, G: 120, B: 40, A: 255}),
+			"1002": Photo(color.RGBA{R: 40, G: 160, B: 90, A: 255}),
+		},
+		TeacherPhotos: map[string]string{
+			"501": Photo(color.RGBA{R: 150, G: 80, B: 160, A: 255}),
+			"502": Photo(color.RGBA{R: 60, G: 140, B: 200, A: 255}),
+		},
+	}
+}
@@ -0,0 +1,475 @@
+// Package edulinktest provides an in-process fake of the EduLink JSON-RPC API
+// for tests and demos.
+package edulinktest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+)
+
+// Failure is an injected failure for the next calls to a method
+type Failure struct {
+	// StatusCode is the HTTP status to answer with, 0 answers 200 with success set to false
+	StatusCode int
+
+	// Message is returned as the EduLink error message
+	Message string
+
+	// Drop closes the connection without answering, simulating a network failure
+	Drop bool
+}
+
+// Server is a fake EduLink API. It checks the x-api-method header and the
+// bearer token the same way the real service does and answers from Fixtures.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	fixtures  *Fixtures
+	tokens    map[string]string
+	failures  map[string][]Failure
+	latency   map[string]time.Duration
+	calls     map[string]int
+	requestID int
+}
+
+// NewServer starts a fake EduLink API serving the given fixtures, or
+// DefaultFixtures when nil. Close it when done.
+func NewServer(fixtures *Fixtures) *Server {
+	if fixtures == nil {
+		fixtures = DefaultFixtures()
+	}
+
+	s := &Server{
+		fixtures: fixtures,
+		tokens:   map[string]string{},
+		failures: map[string][]Failure{},
+		latency:  map[string]time.Duration{},
+		calls:    map[string]int{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// Endpoint is the API URL to configure edulink.Client with
+func (s *Server) Endpoint() string {
+	return s.URL + "/api/"
+}
+
+// School is the fake school, as the provisioning service resolves it
+func (s *Server) School() *edulink.School {
+	return &edulink.School{
+		Code:            s.fixtures.SchoolCode,
+		Endpoint:        s.Endpoint(),
+		EstablishmentID: s.fixtures.EstablishmentID,
+	}
+}
+
+// Resolver returns a resolver that looks school codes up in the fake
+// provisioning service, which only knows Fixtures.SchoolCode
+func (s *Server) Resolver() *edulink.ProvisioningResolver {
+	return edulink.NewProvisioningResolver(&edulink.ProvisioningResolverOptions{
+		Endpoint:   s.URL + "/provisioning/",
+		HTTPClient: s.Client(),
+	})
+}
+
+// ClientOptions returns client options pointing at the fake server
+func (s *Server) ClientOptions() *edulink.ClientOptions {
+	return &edulink.ClientOptions{
+		School:     s.School(),
+		HTTPClient: s.Client(),
+	}
+}
+
+// Fail makes the next times calls to method fail
+func (s *Server) Fail(method string, failure Failure, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < times; i++ {
+		s.failures[method] = append(s.failures[method], failure)
+	}
+}
+
+// SetLatency delays every answer to method by d
+func (s *Server) SetLatency(method string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency[method] = d
+}
+
+// ExpireSessions invalidates every auth token handed out so far
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens = map[string]string{}
+}
+
+// Calls returns how many times method has been called
+func (s *Server) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[method]
+}
+
+type rpcRequest struct {
+	ID        int             `json:"id"`
+	JsonRPC   string          `json:"jsonrpc"`
+	Method    string          `json:"method"`
+	AuthToken string          `json:"authtoken"`
+	Params    json.RawMessage `json:"params"`
+}
+
+// unauthenticatedMethods can be called without a bearer token
+var unauthenticatedMethods = []string{"EduLink.Login", "EduLink.SchoolDetails"}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var req rpcRequest
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/provisioning/") {
+		s.serveProvisioning(w, req)
+		return
+	}
+
+	s.mu.Lock()
+	s.calls[req.Method]++
+	s.requestID++
+	uniqueID := fmt.Sprintf("edulinktest-%d", s.requestID)
+	latency := s.latency[req.Method]
+	var failure *Failure
+	if failures := s.failures[req.Method]; len(failures) > 0 {
+		failure = &failures[0]
+		s.failures[req.Method] = failures[1:]
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if failure != nil {
+		s.fail(w, req, uniqueID, failure)
+		return
+	}
+
+	if r.Header.Get("x-api-method") != req.Method {
+		s.writeError(w, req, uniqueID, http.StatusOK, "Invalid method")
+		return
+	}
+
+	username := ""
+	if !contains(unauthenticatedMethods, req.Method) {
+		var ok bool
+		if username, ok = s.authenticate(r, req); !ok {
+			s.writeError(w, req, uniqueID, http.StatusOK, "The session has expired or is invalid")
+			return
+		}
+	}
+
+	result, message := s.dispatch(req, username)
+	if message != "" {
+		s.writeError(w, req, uniqueID, http.StatusOK, message)
+		return
+	}
+
+	base := edulink.ResultBase{Method: req.Method, Success: true}
+	base.Metrics.UniqueID = uniqueID
+	base.Metrics.St = time.Now()
+
+	s.write(w, req, base, result)
+}
+
+func (s *Server) serveProvisioning(w http.ResponseWriter, req rpcRequest) {
+	var params edulink.SchoolFromCodeRequestParams
+	json.Unmarshal(req.Params, &params)
+
+	s.mu.Lock()
+	s.calls[req.Method]++
+	s.requestID++
+	uniqueID := fmt.Sprintf("edulinktest-%d", s.requestID)
+	var failure *Failure
+	if failures := s.failures[req.Method]; len(failures) > 0 {
+		failure = &failures[0]
+		s.failures[req.Method] = failures[1:]
+	}
+	s.mu.Unlock()
+
+	if failure != nil {
+		s.fail(w, req, uniqueID, failure)
+		return
+	}
+
+	if req.Method != "School.FromCode" || !strings.EqualFold(params.Code, s.fixtures.SchoolCode) {
+		s.writeError(w, req, uniqueID, http.StatusOK, "School not found")
+		return
+	}
+
+	base := edulink.ResultBase{Method: req.Method, Success: true}
+	base.Metrics.UniqueID = uniqueID
+
+	s.write(w, req, base, map[string]interface{}{
+		"school": map[string]interface{}{
+			"server":    s.Endpoint(),
+			"school_id": s.fixtures.EstablishmentID,
+		},
+	})
+}
+
+func (s *Server) fail(w http.ResponseWriter, req rpcRequest, uniqueID string, failure *Failure) {
+	if failure.Drop {
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+
+	statusCode := failure.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+
+	message := failure.Message
+	if message == "" {
+		message = "Injected failure"
+	}
+
+	s.writeError(w, req, uniqueID, statusCode, message)
+}
+
+func (s *Server) authenticate(r *http.Request, req rpcRequest) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token != req.AuthToken {
+		return "", false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username, ok := s.tokens[token]
+	return username, ok
+}
+
+func (s *Server) writeError(w http.ResponseWriter, req rpcRequest, uniqueID string, statusCode int, message string) {
+	base := edulink.ResultBase{Method: req.Method, Success: false, Error: message}
+	base.Metrics.UniqueID = uniqueID
+	base.Metrics.St = time.Now()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(edulink.ResponseBase{ID: req.ID, JsonRPC: "2.0", Result: base})
+}
+
+// write merges the result fields with the result base and sends the response
+func (s *Server) write(w http.ResponseWriter, req rpcRequest, base edulink.ResultBase, result map[string]interface{}) {
+	baseBytes, _ := json.Marshal(base)
+	merged := map[string]interface{}{}
+	json.Unmarshal(baseBytes, &merged)
+	for k, v := range result {
+		merged[k] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      req.ID,
+		"jsonrpc": "2.0",
+		"result":  merged,
+	})
+}
+
+func (s *Server) dispatch(req rpcRequest, username string) (map[string]interface{}, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f := s.fixtures
+
+	switch req.Method {
+	case "EduLink.Login":
+		var params edulink.LoginRequestParams
+		json.Unmarshal(req.Params, &params)
+
+		if params.EstablishmentID != f.EstablishmentID {
+			return nil, "Unknown establishment"
+		}
+
+		for _, account := range f.Accounts {
+			if account.Username == params.Username && account.Password == params.Password {
+				token := newToken()
+				s.tokens[token] = account.Username
+
+				return map[string]interface{}{
+					"api_version": 1,
+					"authtoken":   token,
+					"user": map[string]interface{}{
+						"id":               account.Username,
+						"establishment_id": fmt.Sprint(f.EstablishmentID),
+						"forename":         account.Forename,
+						"surname":          account.Surname,
+						"types":            []string{"parent"},
+						"username":         account.Username,
+					},
+					"children":      account.Children,
+					"establishment": f.Establishment,
+				}, ""
+			}
+		}
+
+		return nil, "The username or password is incorrect"
+
+	case "EduLink.SchoolDetails":
+		var params edulink.SchoolDetailsRequestParams
+		json.Unmarshal(req.Params, &params)
+
+		if params.EstablishmentID != f.EstablishmentID {
+			return nil, "Unknown establishment"
+		}
+
+		return map[string]interface{}{"establishment": f.Establishment}, ""
+
+	case "EduLink.AchievementBehaviourLookups":
+		return map[string]interface{}{
+			"achievement_types": f.AchievementTypes,
+			"behaviour_types":   f.BehaviourTypes,
+		}, ""
+
+	case "EduLink.Behaviour":
+		var params edulink.BehaviourRequestParams
+		json.Unmarshal(req.Params, &params)
+
+		if !s.isParentOf(username, params.LearnerID) {
+			return nil, "Learner not found"
+		}
+
+		behaviour := f.Behaviour[params.LearnerID]
+		if behaviour == nil {
+			behaviour = []edulink.Behaviour{}
+		}
+
+		employeeIDs := []string{}
+		for _, b := range behaviour {
+			employeeIDs = append(employeeIDs, b.InvolvedEmployeeIDs...)
+		}
+
+		return map[string]interface{}{
+			"behaviour":  behaviour,
+			"detentions": []interface{}{},
+			"employees":  s.employees(employeeIDs),
+		}, ""
+
+	case "EduLink.Achievement":
+		var params edulink.AchievementRequestParams
+		json.Unmarshal(req.Params, &params)
+
+		if !s.isParentOf(username, params.LearnerID) {
+			return nil, "Learner not found"
+		}
+
+		achievement := f.Achievement[params.LearnerID]
+		if achievement == nil {
+			achievement = []edulink.Achievement{}
+		}
+
+		employeeIDs := []string{}
+		for _, a := range achievement {
+			employeeIDs = append(employeeIDs, a.InvolvedEmployeeIDs...)
+		}
+
+		return map[string]interface{}{
+			"achievement": achievement,
+			"employees":   s.employees(employeeIDs),
+		}, ""
+
+	case "EduLink.LearnerPhotos":
+		var params edulink.LearnerPhotosRequestParams
+		json.Unmarshal(req.Params, &params)
+
+		photos := []edulink.LearnerPhoto{}
+		for _, id := range params.LearnerIDs {
+			if photo, ok := f.LearnerPhotos[id]; ok && s.isParentOf(username, id) {
+				photos = append(photos, edulink.LearnerPhoto{ID: id, Cache: id, Photo: photo})
+			}
+		}
+
+		return map[string]interface{}{"learner_photos": photos}, ""
+
+	case "EduLink.TeacherPhotos":
+		var params edulink.TeacherPhotosRequestParams
+		json.Unmarshal(req.Params, &params)
+
+		photos := []edulink.TeacherPhoto{}
+		for _, id := range params.EmployeeIDs {
+			if photo, ok := f.TeacherPhotos[id]; ok {
+				photos = append(photos, edulink.TeacherPhoto{ID: id, Cache: id, Photo: photo})
+			}
+		}
+
+		return map[string]interface{}{"employee_photos": photos}, ""
+	}
+
+	return nil, fmt.Sprintf("Method not found: %s", req.Method)
+}
+
+func (s *Server) isParentOf(username string, learnerID string) bool {
+	for _, account := range s.fixtures.Accounts {
+		if account.Username != username {
+			continue
+		}
+
+		for _, child := range account.Children {
+			if child.ID == learnerID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *Server) employees(ids []string) []edulink.Employee {
+	employees := []edulink.Employee{}
+	for _, employee := range s.fixtures.Employees {
+		if contains(ids, employee.ID) {
+			employees = append(employees, employee)
+		}
+	}
+
+	return employees
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func contains(slice []string, v string) bool {
+	for _, item := range slice {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package edulink
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrAuthentication is returned when EduLink rejects the credentials or the auth token
+	ErrAuthentication = errors.New("authentication failed")
+
+	// ErrSessionExpired is returned when the auth token has expired or is not
+	// valid, it also matches ErrAuthentication
+	ErrSessionExpired = errors.New("session expired or auth token invalid")
+
+	// ErrRateLimited is returned when EduLink asks us to slow down
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrTransport is returned when the API could not be reached or the response could not be read
+	ErrTransport = errors.New("transport error")
+)
+
+// APIError is returned when EduLink answers a call with success set to false
+type APIError struct {
+	// Method is the JSON-RPC method that failed
+	Method string
+
+	// Message is the error message returned by EduLink, if any
+	Message string
+
+	// UniqueID is the request ID from the response metrics, quote it when reporting issues
+	UniqueID string
+
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+
+	// Kind classifies the failure, one of ErrAuthentication, ErrSessionExpired,
+	// ErrRateLimited or nil
+	Kind error
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = "API call failed"
+	}
+
+	if e.UniqueID != "" {
+		return fmt.Sprintf("%s: %s (unique_id: %s)", e.Method, message, e.UniqueID)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Method, message)
+}
+
+func (e *APIError) Is(target error) bool {
+	if e.Kind == nil {
+		return false
+	}
+
+	if e.Kind == ErrSessionExpired && target == ErrAuthentication {
+		return true
+	}
+
+	return e.Kind == target
+}
+
+// TransportError wraps failures to reach EduLink or to read its response, it matches ErrTransport
+type TransportError struct {
+	Method string
+	Err    error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Method, ErrTransport, e.Err)
+}
+
+func (e *TransportError) Is(target error) bool {
+	return target == ErrTransport
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,93 @@
+package edulink
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// InlineImage is a photo sent as an inline attachment of a report email
+type InlineImage struct {
+	// ContentID is referenced as cid:ContentID from the report. It doubles as
+	// the filename since Mailgun derives the content ID from the filename.
+	ContentID   string
+	ContentType string
+	Data        []byte
+}
+
+func newInlineImage(kind string, id string, photo string) *InlineImage {
+	if photo == "" {
+		return nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(photo)
+	if err != nil {
+		return nil
+	}
+
+	contentType := http.DetectContentType(data)
+	extension := "png"
+	switch contentType {
+	case "image/jpeg":
+		extension = "jpg"
+	case "image/gif":
+		extension = "gif"
+	case "image/webp":
+		extension = "webp"
+	case "image/png":
+	default:
+		contentType = "image/png"
+	}
+
+	return &InlineImage{
+		ContentID:   fmt.Sprintf("%s-%s.%s", kind, id, extension),
+		ContentType: contentType,
+		Data:        data,
+	}
+}
+
+func (s *SchoolReport) pupilImage() *InlineImage {
+	return newInlineImage("pupil", s.Child.ID, s.Photo)
+}
+
+func (s *SchoolReport) teacherImage(teacherID string) *InlineImage {
+	for _, teacherPhoto := range s.TeacherPhotos {
+		if teacherPhoto.ID == teacherID {
+			return newInlineImage("teacher", teacherID, teacherPhoto.Photo)
+		}
+	}
+	return nil
+}
+
+// InlineImages returns the pupil photo and teacher photos of the report, as
+// referenced by the email rendering of the report
+func (s *SchoolReport) InlineImages() []InlineImage {
+	images := []InlineImage{}
+
+	if image := s.pupilImage(); image != nil {
+		images = append(images, *image)
+	}
+
+	for _, teacherPhoto := range s.TeacherPhotos {
+		if image := s.teacherImage(teacherPhoto.ID); image != nil {
+			images = append(images, *image)
+		}
+	}
+
+	return images
+}
+
+// imageSrc returns the src of a photo in the report: a cid: reference to the
+// inline attachment for email, or a data: URI for the web.
+func imageSrc(image *InlineImage, email bool) template.URL {
+	if image == nil {
+		return ""
+	}
+
+	if email {
+		return template.URL("cid:" + image.ContentID)
+	}
+
+	return template.URL(fmt.Sprintf("data:%s;base64,%s", image.ContentType, base64.StdEncoding.EncodeToString(image.Data)))
+}
@@ -0,0 +1,51 @@
+package edulink_test
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var imageSrc = regexp.MustCompile(`src="([^"]*)"`)
+
+// every photo of an email is an inline attachment referenced by cid:
+func TestGenerateEmailReferencesInlineImages(t *testing.T) {
+	reporter, reports := prepareReports(t)
+
+	for _, report := range reports {
+		t.Run(report.Child.Forename, func(t *testing.T) {
+			referenced := []string{}
+			for _, match := range imageSrc.FindAllStringSubmatch(reporter.GenerateEmail(&report), -1) {
+				src := match[1]
+				if !strings.HasPrefix(src, "cid:") {
+					t.Errorf("image %.40s is not an inline attachment", src)
+					continue
+				}
+				if id := strings.TrimPrefix(src, "cid:"); !slices.Contains(referenced, id) {
+					referenced = append(referenced, id)
+				}
+			}
+
+			attached := []string{}
+			for _, image := range report.InlineImages() {
+				if len(image.Data) == 0 || !strings.HasPrefix(image.ContentType, "image/") {
+					t.Errorf("attachment %s is %d bytes of %s", image.ContentID, len(image.Data), image.ContentType)
+				}
+				attached = append(attached, image.ContentID)
+			}
+
+			sort.Strings(referenced)
+			sort.Strings(attached)
+			if len(attached) == 0 || !slices.Equal(referenced, attached) {
+				t.Errorf("email references %v, attachments are %v", referenced, attached)
+			}
+
+			// the web keeps its photos in the page
+			if strings.Contains(reporter.Generate(&report), `src="cid:`) {
+				t.Error("web report references inline attachments")
+			}
+		})
+	}
+}
@@ -1,9 +1,9 @@
 package edulink
 
-import "time"
-
-const API_ENDPOINT = "https://roundwoodpark.edulinkone.com/api/"
-const SCHOOL_ID = 2
+import (
+	"log"
+	"time"
+)
 
 type Request interface {
 	GetBaseRequest() RequestBase
@@ -28,6 +28,7 @@ type RequestBase struct {
 type ResultBase struct {
 	Method  string `json:"method"`
 	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
 
 	Metrics struct {
 		Be       string    `json:"be"`
@@ -55,6 +56,56 @@ type SchoolReport struct {
 	School        Establishment  `json:"school"`
 	Teachers      []Employee     `json:"teachers"`
 	TeacherPhotos []TeacherPhoto `json:"teacher_photos"`
+
+	// Errors lists the calls that failed while preparing the report
+	Errors []string `json:"errors"`
+}
+
+// AchievementIDs lists the IDs of the reported achievements
+func (s *SchoolReport) AchievementIDs() []string {
+	ids := []string{}
+	for _, achievement := range s.Achievement {
+		ids = append(ids, achievement.ID)
+	}
+	return ids
+}
+
+// BehaviourIDs lists the IDs of the reported behaviours
+func (s *SchoolReport) BehaviourIDs() []string {
+	ids := []string{}
+	for _, behaviour := range s.Behaviour {
+		ids = append(ids, behaviour.ID)
+	}
+	return ids
+}
+
+// AchievementPoints is the sum of the points of the reported achievements
+func (s *SchoolReport) AchievementPoints() int {
+	points := 0
+	for _, achievement := range s.Achievement {
+		points += achievement.Points
+	}
+	return points
+}
+
+// BehaviourPoints is the sum of the points of the reported behaviours,
+// negative for poor behaviour
+func (s *SchoolReport) BehaviourPoints() int {
+	points := 0
+	for _, behaviour := range s.Behaviour {
+		points += behaviour.Points
+	}
+	return points
+}
+
+// NetPoints is the balance of achievement and behaviour points
+func (s *SchoolReport) NetPoints() int {
+	return s.AchievementPoints() + s.BehaviourPoints()
+}
+
+func (s *SchoolReport) addError(err error) {
+	log.Printf("Error preparing report for %s: %s\n", s.Child.Forename, err)
+	s.Errors = append(s.Errors, err.Error())
 }
 
 type ErrNotFound struct{}
@@ -0,0 +1,96 @@
+package edulink_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/edulink/edulinktest"
+)
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		calls int
+		// want is how long the calls take at least, they get a 100ms margin
+		want time.Duration
+	}{
+		{name: "within the burst", rate: 10, burst: 5, calls: 5},
+		{name: "beyond the burst", rate: 10, burst: 2, calls: 5, want: 300 * time.Millisecond},
+		{name: "burst below one", rate: 20, burst: 0, calls: 3, want: 100 * time.Millisecond},
+		{name: "no rate is no limit", rate: 0, burst: 1, calls: 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := edulink.NewRateLimiter(test.rate, test.burst)
+
+			start := time.Now()
+			for i := 0; i < test.calls; i++ {
+				if err := limiter.Wait(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if took := time.Since(start); took < test.want || took > test.want+100*time.Millisecond {
+				t.Errorf("%d calls took %s, want %s", test.calls, took, test.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterCancelled(t *testing.T) {
+	limiter := edulink.NewRateLimiter(5, 1)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the context's error", err)
+	}
+
+	// the cancelled call gave its token back, the next one is 200ms after
+	// the first rather than 400ms
+	start := time.Now()
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > 250*time.Millisecond {
+		t.Errorf("waited %s after a cancelled call, want under 250ms", took)
+	}
+}
+
+// clients sharing a limiter are limited together
+func TestRateLimiterSharedByClients(t *testing.T) {
+	server := edulinktest.NewServer(nil)
+	defer server.Close()
+
+	limiter := edulink.NewRateLimiter(10, 2)
+	clients := make([]*edulink.Client, 2)
+	for i := range clients {
+		options := server.ClientOptions()
+		options.RateLimiter = limiter
+		client, err := edulink.NewClient(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients[i] = client
+	}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := schoolDetails(context.Background(), clients[i%2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if took := time.Since(start); took < 200*time.Millisecond {
+		t.Errorf("4 calls through two clients took %s, want at least 200ms", took)
+	}
+}
@@ -0,0 +1,224 @@
+package edulink
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type RecordingMode int
+
+const (
+	// Record forwards requests to EduLink and saves every exchange
+	Record RecordingMode = iota
+	// Replay answers requests from saved exchanges without touching the network
+	Replay
+)
+
+const redacted = "REDACTED"
+
+// ParseRecordingMode parses "record" or "replay"
+func ParseRecordingMode(value string) (RecordingMode, error) {
+	switch strings.ToLower(value) {
+	case "record":
+		return Record, nil
+	case "replay":
+		return Replay, nil
+	}
+
+	return 0, fmt.Errorf("unknown recording mode %q, expected record or replay", value)
+}
+
+// RecordingTransport is an http.RoundTripper that records EduLink traffic to
+// a fixtures directory or replays it from there. Passwords and auth tokens
+// are scrubbed before anything is written to disk.
+type RecordingTransport struct {
+	mode      RecordingMode
+	dir       string
+	transport http.RoundTripper
+
+	mu sync.Mutex
+}
+
+type RecordingTransportOptions struct {
+	Mode RecordingMode
+
+	// Dir holds one JSON file per recorded exchange
+	Dir string
+
+	// Transport sends requests in Record mode, defaults to http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+func NewRecordingTransport(o *RecordingTransportOptions) *RecordingTransport {
+	t := &RecordingTransport{
+		mode:      o.Mode,
+		dir:       o.Dir,
+		transport: o.Transport,
+	}
+
+	if t.transport == nil {
+		t.transport = http.DefaultTransport
+	}
+
+	return t
+}
+
+type recordedExchange struct {
+	Method   string           `json:"method"`
+	Request  json.RawMessage  `json:"request"`
+	Response recordedResponse `json:"response"`
+}
+
+type recordedResponse struct {
+	StatusCode int             `json:"status_code"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+
+	apiMethod := req.Header.Get("x-api-method")
+	scrubbedRequest, err := scrubRequest(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(t.dir, fixtureName(apiMethod, scrubbedRequest))
+
+	if t.mode == Replay {
+		return t.replay(req, path)
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.save(path, apiMethod, scrubbedRequest, resp.StatusCode, respBody); err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+func (t *RecordingTransport) replay(req *http.Request, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no recording for %s: %w", req.Header.Get("x-api-method"), err)
+	}
+
+	var exchange recordedExchange
+	if err := json.Unmarshal(data, &exchange); err != nil {
+		return nil, fmt.Errorf("reading recording %s: %w", path, err)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Response.StatusCode, http.StatusText(exchange.Response.StatusCode)),
+		StatusCode:    exchange.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(exchange.Response.Body)),
+		ContentLength: int64(len(exchange.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (t *RecordingTransport) save(path string, apiMethod string, scrubbedRequest []byte, statusCode int, respBody []byte) error {
+	body := json.RawMessage(scrubResponse(respBody))
+	if !json.Valid(body) {
+		// keep non JSON answers, such as error pages, as a JSON string
+		body, _ = json.Marshal(string(respBody))
+	}
+
+	data, err := json.MarshalIndent(recordedExchange{
+		Method:   apiMethod,
+		Request:  scrubbedRequest,
+		Response: recordedResponse{StatusCode: statusCode, Body: body},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o644)
+}
+
+// fixtureName identifies an exchange by method and scrubbed request, so the
+// same call always maps to the same file whatever its auth token or ID
+func fixtureName(apiMethod string, scrubbedRequest []byte) string {
+	hash := sha256.Sum256(scrubbedRequest)
+	return fmt.Sprintf("%s-%s.json", apiMethod, hex.EncodeToString(hash[:8]))
+}
+
+// scrubRequest drops the parts of a request that change between runs or must
+// not be stored, and returns it in canonical form
+func scrubRequest(body []byte) ([]byte, error) {
+	var request map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("recording request: %w", err)
+	}
+
+	delete(request, "id")
+	delete(request, "uuid")
+	delete(request, "authtoken")
+
+	if params, ok := request["params"].(map[string]interface{}); ok {
+		if _, ok := params["password"]; ok {
+			params["password"] = redacted
+		}
+	}
+
+	return json.Marshal(request)
+}
+
+func scrubResponse(body []byte) []byte {
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return body
+	}
+
+	if result, ok := response["result"].(map[string]interface{}); ok {
+		if _, ok := result["authtoken"]; ok {
+			result["authtoken"] = redacted
+		}
+	}
+
+	scrubbed, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return body
+	}
+
+	return scrubbed
+}
@@ -0,0 +1,129 @@
+package edulink_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/edulink/edulinktest"
+)
+
+// newRecordingSession logs in as the fixtures' parent through a recording
+// transport
+func newRecordingSession(t *testing.T, server *edulinktest.Server, mode edulink.RecordingMode, dir string) *edulink.Session {
+	t.Helper()
+
+	options := server.ClientOptions()
+	options.Retry = testRetry
+	options.HTTPClient = &http.Client{
+		Transport: edulink.NewRecordingTransport(&edulink.RecordingTransportOptions{
+			Mode:      mode,
+			Dir:       dir,
+			Transport: options.HTTPClient.Transport,
+		}),
+	}
+
+	client, err := edulink.NewClient(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return edulink.NewSession(&edulink.SessionOptions{Client: client, Username: "parent", Password: "password"})
+}
+
+func TestRecordingScrubsCredentials(t *testing.T) {
+	ctx := context.Background()
+	server := edulinktest.NewServer(nil)
+	defer server.Close()
+
+	dir := t.TempDir()
+	session := newRecordingSession(t, server, edulink.Record, dir)
+	login, err := session.Login(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := achievement(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("recorded %v, %v, want the login and the achievement", files, err)
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), login.Result.AuthToken) {
+			t.Errorf("%s has the auth token:\n%s", filepath.Base(file), data)
+		}
+
+		var exchange struct {
+			Method  string `json:"method"`
+			Request struct {
+				AuthToken *string `json:"authtoken"`
+				Params    struct {
+					Password *string `json:"password"`
+				} `json:"params"`
+			} `json:"request"`
+			Response struct {
+				Body struct {
+					Result struct {
+						AuthToken *string `json:"authtoken"`
+					} `json:"result"`
+				} `json:"body"`
+			} `json:"response"`
+		}
+		if err := json.Unmarshal(data, &exchange); err != nil {
+			t.Fatal(err)
+		}
+
+		if exchange.Request.AuthToken != nil {
+			t.Errorf("%s request has an authtoken", exchange.Method)
+		}
+		if password := exchange.Request.Params.Password; exchange.Method == "EduLink.Login" && (password == nil || *password != "REDACTED") {
+			t.Errorf("login request password %v, want REDACTED", password)
+		}
+		if token := exchange.Response.Body.Result.AuthToken; exchange.Method == "EduLink.Login" && (token == nil || *token != "REDACTED") {
+			t.Errorf("login response authtoken %v, want REDACTED", token)
+		}
+	}
+}
+
+func TestRecordingReplays(t *testing.T) {
+	ctx := context.Background()
+	server := edulinktest.NewServer(nil)
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorded := newRecordingSession(t, server, edulink.Record, dir)
+	var want edulink.AchievementResponse
+	if err := recorded.Call(ctx, achievementRequest(), &want); err != nil {
+		t.Fatal(err)
+	}
+	calls := server.Calls("EduLink.Achievement")
+
+	replayed := newRecordingSession(t, server, edulink.Replay, dir)
+	var got edulink.AchievementResponse
+	if err := replayed.Call(ctx, achievementRequest(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.Calls("EduLink.Achievement") != calls || server.Calls("EduLink.Login") != 1 {
+		t.Error("replay called the server")
+	}
+	if len(got.Result.Achievement) == 0 || len(got.Result.Achievement) != len(want.Result.Achievement) {
+		t.Errorf("replayed %d achievements, recorded %d", len(got.Result.Achievement), len(want.Result.Achievement))
+	}
+
+	// calls that were not recorded fail rather than reaching EduLink
+	if err := schoolDetails(ctx, replayed.Client()); err == nil {
+		t.Error("replayed a call that was not recorded")
+	}
+}
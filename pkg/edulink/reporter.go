@@ -9,10 +9,11 @@ import (
 	"log"
 	"os"
 	"slices"
+	"strings"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/eu-evops/edulink/pkg/cache"
-	"github.com/eu-evops/edulink/pkg/cache/common"
 )
 
 const (
@@ -33,12 +34,14 @@ type Reporter struct {
 
 	templatesPrepared bool
 	template          *template.Template
+	textTemplate      *texttemplate.Template
 }
 
 type ReporterOptions struct {
-	Username string
-	Password string
+	Session *Session
 
+	// Cache keeps the already seen state, accounts sharing a school use one
+	// scoped to them, see cache.WithPrefix
 	Cache *cache.Cache
 }
 
@@ -115,6 +118,9 @@ func (r *Reporter) prepareTemplates(schoolReport *SchoolReport) {
 			}
 			return fmt.Sprintf("%d %ss", val, text)
 		},
+		"signed": func(val int) string {
+			return fmt.Sprintf("%+d", val)
+		},
 		"teacher": func(teacherID string) *Employee {
 			for _, employee := range r.teachers {
 				if employee.ID == teacherID {
@@ -131,6 +137,9 @@ func (r *Reporter) prepareTemplates(schoolReport *SchoolReport) {
 			}
 			return nil
 		},
+		// pupilPhotoSrc and teacherPhotoSrc are bound to the report being rendered in generate
+		"pupilPhotoSrc":   func() template.URL { return "" },
+		"teacherPhotoSrc": func(teacherID string) template.URL { return "" },
 		"wrap": func(pairs ...interface{}) map[string]interface{} {
 			m := make(map[string]interface{})
 			for i := 0; i < len(pairs); i += 2 {
@@ -159,9 +168,132 @@ func (r *Reporter) prepareTemplates(schoolReport *SchoolReport) {
 
 	reportTemplate := []string{"templates/edulink.schoolreport.go.tmpl"}
 	r.template = template.Must(template.New("edulink.schoolreport.go.tmpl").Funcs(fmap).ParseFiles(reportTemplate...))
+
+	textTemplate := []string{"templates/edulink.schoolreport.txt.tmpl"}
+	r.textTemplate = texttemplate.Must(texttemplate.New("edulink.schoolreport.txt.tmpl").Funcs(texttemplate.FuncMap(fmap)).ParseFiles(textTemplate...))
 	r.templatesPrepared = true
 }
 
+func (r *Reporter) alreadySeenKey(client *Client, name string) string {
+	return fmt.Sprintf("%s:%s", name, client.School().Code)
+}
+
+// alreadySeenSetKey is the set of IDs already reported for the client's school
+func (r *Reporter) alreadySeenSetKey(client *Client, name string) string {
+	return fmt.Sprintf("set:%s", r.alreadySeenKey(client, name))
+}
+
+// MoveAlreadySeen moves the already seen state kept in from with the
+// namespace suffix, as written before accounts had a cache prefix of their
+// own, into the reporter's cache
+func (r *Reporter) MoveAlreadySeen(ctx context.Context, from *cache.Cache, namespace string) error {
+	client := r.options.Session.Client()
+
+	for _, name := range []string{"alreadySeenBehaviourIDs", "alreadySeenAchievementIDs"} {
+		legacyKey := fmt.Sprintf("%s:%s", r.alreadySeenKey(client, name), namespace)
+		legacySetKey := fmt.Sprintf("set:%s", legacyKey)
+
+		ids := []string{}
+		if from.Exists(ctx, legacySetKey) {
+			members, err := from.SetMembers(ctx, legacySetKey)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, members...)
+		}
+
+		// lists written before sets were used
+		if from.Exists(ctx, legacyKey) {
+			list := []string{}
+			if err := from.Get(ctx, legacyKey, &list); err != nil {
+				return err
+			}
+			ids = append(ids, list...)
+		}
+
+		if len(ids) == 0 {
+			continue
+		}
+
+		setKey := r.alreadySeenSetKey(client, name)
+		log.Printf("Moving %d already seen IDs from %s to %s%s\n", len(ids), legacySetKey, r.options.Cache.Prefix(), setKey)
+		if err := r.options.Cache.SetAdd(ctx, setKey, Century, ids...); err != nil {
+			return err
+		}
+
+		for _, key := range []string{legacySetKey, legacyKey} {
+			if err := from.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadAlreadySeen returns the IDs in an already seen set
+func (r *Reporter) loadAlreadySeen(ctx context.Context, client *Client, name string) []string {
+	key := r.alreadySeenSetKey(client, name)
+	r.migrateAlreadySeen(ctx, client, name, key)
+
+	ids, err := r.options.Cache.SetMembers(ctx, key)
+	if err != nil {
+		log.Printf("Could not read %s: %s\n", key, err)
+	}
+	return ids
+}
+
+// migrateAlreadySeen copies an already seen list, as written before sets were
+// used, into the set until the set exists. Lists written before schools were
+// configurable live under the bare name and are read from there until the
+// school list has been written.
+func (r *Reporter) migrateAlreadySeen(ctx context.Context, client *Client, name string, setKey string) {
+	if r.options.Cache.Exists(ctx, setKey) {
+		return
+	}
+
+	key := r.alreadySeenKey(client, name)
+	if !r.options.Cache.Exists(ctx, key) && r.options.Cache.Exists(ctx, name) {
+		log.Printf("Reading %s from legacy key %s\n", key, name)
+		key = name
+	}
+
+	ids := []string{}
+	r.options.Cache.Get(ctx, key, &ids)
+	if len(ids) == 0 {
+		return
+	}
+
+	log.Printf("Moving %d already seen IDs from %s to %s\n", len(ids), key, setKey)
+	if err := r.options.Cache.SetAdd(ctx, setKey, Century, ids...); err != nil {
+		log.Printf("Could not move %s: %s\n", key, err)
+	}
+}
+
+// MarkSeen records behaviours and achievements as reported, so Prepare leaves
+// them out from then on
+func (r *Reporter) MarkSeen(ctx context.Context, behaviourIDs []string, achievementIDs []string) error {
+	client := r.options.Session.Client()
+
+	if err := r.markSeen(ctx, client, "alreadySeenBehaviourIDs", behaviourIDs); err != nil {
+		return err
+	}
+
+	return r.markSeen(ctx, client, "alreadySeenAchievementIDs", achievementIDs)
+}
+
+func (r *Reporter) markSeen(ctx context.Context, client *Client, name string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	key := r.alreadySeenSetKey(client, name)
+	r.migrateAlreadySeen(ctx, client, name, key)
+
+	log.Printf("Marking %s as seen in %s\n", strings.Join(ids, ", "), key)
+	return r.options.Cache.SetAdd(ctx, key, Century, ids...)
+}
+
 type PrepareOptions struct {
 	// MaximumAge is the maximum age of behaviours and achievements to report on
 	MaximumAge time.Duration
@@ -170,59 +302,35 @@ type PrepareOptions struct {
 	ReportPrevious bool
 }
 
-func (r *Reporter) Prepare(options *PrepareOptions) *[]SchoolReport {
+// Prepare collects a report for every child on the account. It only fails when
+// the account cannot log in; failures of individual calls are logged and
+// recorded in SchoolReport.Errors so the rest of the report still goes out.
+// Reported items stay unseen until they are passed to MarkSeen. Its calls to
+// EduLink end when ctx is done.
+func (r *Reporter) Prepare(ctx context.Context, options *PrepareOptions) (*[]SchoolReport, error) {
 	if options == nil {
 		options = &PrepareOptions{
 			MaximumAge: Year,
 		}
 	}
 
-	schoolReports := []SchoolReport{}
-	alreadySeenBehaviourIDs := []string{}
-	alreadySeenAchievementIDs := []string{}
+	session := r.options.Session
+	client := session.Client()
 
-	r.options.Cache.Get(context.Background(), "alreadySeenBehaviourIDs", &alreadySeenBehaviourIDs)
-	r.options.Cache.Get(context.Background(), "alreadySeenAchievementIDs", &alreadySeenAchievementIDs)
+	schoolReports := []SchoolReport{}
+	alreadySeenBehaviourIDs := r.loadAlreadySeen(ctx, client, "alreadySeenBehaviourIDs")
+	alreadySeenAchievementIDs := r.loadAlreadySeen(ctx, client, "alreadySeenAchievementIDs")
 
 	fmt.Println("Already seen behaviour IDs:", alreadySeenBehaviourIDs)
 	fmt.Println("Already seen achievement IDs:", alreadySeenAchievementIDs)
 
-	defer (func() {
-		r.options.Cache.Set(&common.Item{
-			Ctx:   context.Background(),
-			Key:   "alreadySeenBehaviourIDs",
-			Value: alreadySeenBehaviourIDs,
-			TTL:   Century,
-		})
-
-		r.options.Cache.Set(&common.Item{
-			Ctx:   context.Background(),
-			Key:   "alreadySeenAchievementIDs",
-			Value: alreadySeenAchievementIDs,
-			TTL:   Century,
-		})
-
-		fmt.Println("Already seen behaviour IDs:", alreadySeenBehaviourIDs)
-		fmt.Println("Already seen achievement IDs:", alreadySeenAchievementIDs)
-	})()
-
-	loginReq := LoginRequest{
-		RequestBase: RequestBase{
-			ID:      1,
-			JsonRPC: "2.0",
-			Method:  "EduLink.Login",
-		},
-		Params: LoginRequestParams{
-			Username:        r.options.Username,
-			Password:        r.options.Password,
-			EstablishmentID: SCHOOL_ID,
-		},
+	loginResponse, err := session.Login(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	var loginResponse LoginResponse
-	if err := Call(context.Background(), loginReq, &loginResponse); err != nil {
-		panic(err)
-	}
+	// errors from calls shared by all children, copied onto every report
+	sharedErrors := []string{}
 
 	schoolDetailsReq := SchoolDetailsRequest{
 		RequestBase: RequestBase{
@@ -231,25 +339,26 @@ func (r *Reporter) Prepare(options *PrepareOptions) *[]SchoolReport {
 			Method:  "EduLink.SchoolDetails",
 		},
 		Params: SchoolDetailsRequestParams{
-			EstablishmentID: SCHOOL_ID,
+			EstablishmentID: client.EstablishmentID(),
 		},
 	}
 	var schoolDetailsResp SchoolDetailsResponse
-	if err := Call(context.Background(), schoolDetailsReq, &schoolDetailsResp); err != nil {
-		panic(err)
+	if err := client.Call(ctx, schoolDetailsReq, &schoolDetailsResp); err != nil {
+		log.Printf("Could not get school details: %s\n", err)
+		sharedErrors = append(sharedErrors, err.Error())
 	}
 
 	achievementBehaviourLookups := AchievementBehaviourLookupsRequest{
 		RequestBase: RequestBase{
-			ID:        1,
-			JsonRPC:   "2.0",
-			Method:    "EduLink.AchievementBehaviourLookups",
-			AuthToken: loginResponse.Result.AuthToken,
+			ID:      1,
+			JsonRPC: "2.0",
+			Method:  "EduLink.AchievementBehaviourLookups",
 		},
 	}
 	var achievementBehaviourLookupsResponse AchievementBehaviourLookupsResponse
-	if err := Call(context.Background(), achievementBehaviourLookups, &achievementBehaviourLookupsResponse); err != nil {
-		panic(err)
+	if err := session.Call(ctx, &achievementBehaviourLookups, &achievementBehaviourLookupsResponse); err != nil {
+		log.Printf("Could not get achievement and behaviour lookups: %s\n", err)
+		sharedErrors = append(sharedErrors, err.Error())
 	}
 
 	r.SetAchievementTypes(achievementBehaviourLookupsResponse.Result.AchievementTypes)
@@ -260,37 +369,37 @@ func (r *Reporter) Prepare(options *PrepareOptions) *[]SchoolReport {
 
 		photoReq := &LearnerPhotosRequest{
 			RequestBase: RequestBase{
-				ID:        1,
-				JsonRPC:   "2.0",
-				Method:    "EduLink.LearnerPhotos",
-				AuthToken: loginResponse.Result.AuthToken,
+				ID:      1,
+				JsonRPC: "2.0",
+				Method:  "EduLink.LearnerPhotos",
 			},
 			Params: LearnerPhotosRequestParams{
 				LearnerIDs: []string{child.ID},
 				Size:       256,
 			},
 		}
-		var photoResponse LearnerPhotosResponse
-		if err := Call(context.Background(), photoReq, &photoResponse); err != nil {
-			panic(err)
-		}
-
 		schoolReport := &SchoolReport{
 			Child:         child,
-			Photo:         photoResponse.Result.LearnerPhotos[0].Photo,
 			School:        schoolDetailsResp.Result.Establishment,
 			Behaviour:     []Behaviour{},
 			Achievement:   []Achievement{},
 			Teachers:      []Employee{},
 			TeacherPhotos: []TeacherPhoto{},
+			Errors:        append([]string{}, sharedErrors...),
+		}
+
+		var photoResponse LearnerPhotosResponse
+		if err := session.Call(ctx, photoReq, &photoResponse); err != nil {
+			schoolReport.addError(err)
+		} else if len(photoResponse.Result.LearnerPhotos) > 0 {
+			schoolReport.Photo = photoResponse.Result.LearnerPhotos[0].Photo
 		}
 
 		behaviourReq := BehaviourRequest{
 			RequestBase: RequestBase{
-				ID:        1,
-				JsonRPC:   "2.0",
-				Method:    "EduLink.Behaviour",
-				AuthToken: loginResponse.Result.AuthToken,
+				ID:      1,
+				JsonRPC: "2.0",
+				Method:  "EduLink.Behaviour",
 			},
 			Params: BehaviourRequestParams{
 				LearnerID: child.ID,
@@ -299,9 +408,8 @@ func (r *Reporter) Prepare(options *PrepareOptions) *[]SchoolReport {
 		}
 
 		var behaviourResponse BehaviourResponse
-		err := Call(context.Background(), behaviourReq, &behaviourResponse)
-		if err != nil {
-			panic(err)
+		if err := session.Call(ctx, &behaviourReq, &behaviourResponse); err != nil {
+			schoolReport.addError(err)
 		}
 
 		for _, behaviour := range behaviourResponse.Result.Behaviour {
@@ -318,10 +426,9 @@ func (r *Reporter) Prepare(options *PrepareOptions) *[]SchoolReport {
 
 		achievementReq := AchievementRequest{
 			RequestBase: RequestBase{
-				ID:        1,
-				JsonRPC:   "2.0",
-				Method:    "EduLink.Achievement",
-				AuthToken: loginResponse.Result.AuthToken,
+				ID:      1,
+				JsonRPC: "2.0",
+				Method:  "EduLink.Achievement",
 			},
 			Params: AchievementRequestParams{
 				LearnerID: child.ID,
@@ -330,8 +437,8 @@ func (r *Reporter) Prepare(options *PrepareOptions) *[]SchoolReport {
 		}
 
 		var achievementResponse AchievementResponse
-		if err := Call(context.Background(), achievementReq, &achievementResponse); err != nil {
-			panic(err)
+		if err := session.Call(ctx, &achievementReq, &achievementResponse); err != nil {
+			schoolReport.addError(err)
 		}
 
 		for _, achievement := range achievementResponse.Result.Achievement {
@@ -366,10 +473,9 @@ func (r *Reporter) Prepare(options *PrepareOptions) *[]SchoolReport {
 
 		teachersPhotosRequest := &TeacherPhotosRequest{
 			RequestBase: RequestBase{
-				ID:        1,
-				JsonRPC:   "2.0",
-				Method:    "EduLink.TeacherPhotos",
-				AuthToken: loginResponse.Result.AuthToken,
+				ID:      1,
+				JsonRPC: "2.0",
+				Method:  "EduLink.TeacherPhotos",
 			},
 			Params: TeacherPhotosRequestParams{
 				EmployeeIDs: involvedTeacherIDs,
@@ -377,8 +483,10 @@ func (r *Reporter) Prepare(options *PrepareOptions) *[]SchoolReport {
 			},
 		}
 		var teachersPhotosResponse TeacherPhotosResponse
-		if err := Call(context.Background(), teachersPhotosRequest, &teachersPhotosResponse); err != nil {
-			panic(err)
+		if len(involvedTeacherIDs) > 0 {
+			if err := session.Call(ctx, teachersPhotosRequest, &teachersPhotosResponse); err != nil {
+				schoolReport.addError(err)
+			}
 		}
 
 		schoolReport.Teachers = involvedTeachers
@@ -391,12 +499,45 @@ func (r *Reporter) Prepare(options *PrepareOptions) *[]SchoolReport {
 		}
 	}
 
-	return &schoolReports
+	return &schoolReports, nil
 }
 
+// Generate renders the report for the web, with photos embedded as data: URIs
 func (r *Reporter) Generate(schoolReport *SchoolReport) string {
+	return r.generate(schoolReport, false)
+}
+
+// GenerateEmail renders the report for email, with photos referenced as
+// cid: URIs of the attachments returned by SchoolReport.InlineImages
+func (r *Reporter) GenerateEmail(schoolReport *SchoolReport) string {
+	return r.generate(schoolReport, true)
+}
+
+// GenerateText renders the report as plain text, for text only mail clients
+func (r *Reporter) GenerateText(schoolReport *SchoolReport) string {
 	r.prepareTemplates(schoolReport)
 
+	var text bytes.Buffer
+	if err := r.textTemplate.Execute(&text, schoolReport); err != nil {
+		panic(err)
+	}
+
+	return text.String()
+}
+
+func (r *Reporter) generate(schoolReport *SchoolReport, email bool) string {
+	r.prepareTemplates(schoolReport)
+
+	reportTemplate := template.Must(r.template.Clone())
+	reportTemplate.Funcs(template.FuncMap{
+		"pupilPhotoSrc": func() template.URL {
+			return imageSrc(schoolReport.pupilImage(), email)
+		},
+		"teacherPhotoSrc": func(teacherID string) template.URL {
+			return imageSrc(schoolReport.teacherImage(teacherID), email)
+		},
+	})
+
 	style, err := os.ReadFile("templates/style.css")
 	if err != nil {
 		panic(err)
@@ -408,7 +549,7 @@ func (r *Reporter) Generate(schoolReport *SchoolReport) string {
 	}
 
 	var tmpl bytes.Buffer
-	if err := r.template.Execute(&tmpl, schoolReportViewData); err != nil {
+	if err := reportTemplate.Execute(&tmpl, schoolReportViewData); err != nil {
 		panic(err)
 	}
 
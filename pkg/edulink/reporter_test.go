@@ -0,0 +1,80 @@
+package edulink_test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/edulink/edulinktest"
+)
+
+// the report templates are read relative to the repository root
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// prepareReports returns the reports of the default fixtures, dated so they
+// render the same every day
+func prepareReports(t *testing.T) (*edulink.Reporter, []edulink.SchoolReport) {
+	t.Helper()
+
+	fixtures := edulinktest.DefaultFixtures()
+	for i, days := range []int{1, 3} {
+		fixtures.Achievement["1001"][i].Date = edulink.DateOnly(time.Date(2026, 10, 14-days, 0, 0, 0, 0, time.UTC))
+	}
+	fixtures.Achievement["1002"][0].Date = edulink.DateOnly(time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC))
+	fixtures.Behaviour["1001"][0].Date = edulink.DateOnly(time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC))
+
+	server := edulinktest.NewServer(fixtures)
+	t.Cleanup(server.Close)
+
+	reporter := edulink.NewReporter(&edulink.ReporterOptions{
+		Session: newTestSession(t, server, nil, "password"),
+		Cache:   newTestCache(t),
+	})
+	reports, err := reporter.Prepare(context.Background(), &edulink.PrepareOptions{MaximumAge: edulink.Century})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*reports) != 2 {
+		t.Fatalf("prepared %d reports, want 2", len(*reports))
+	}
+	return reporter, *reports
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// the plain text body is compared with testdata, run with -update to
+// rewrite it after changing the template
+func TestGenerateText(t *testing.T) {
+	reporter, reports := prepareReports(t)
+
+	for _, report := range reports {
+		t.Run(report.Child.Forename, func(t *testing.T) {
+			got := reporter.GenerateText(&report)
+
+			golden := filepath.Join("pkg", "edulink", "testdata", fmt.Sprintf("schoolreport.%s.txt", report.Child.ID))
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("text body differs from %s, got:\n%s", golden, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package edulink
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how failed calls are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry, doubled on every further retry
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+}
+
+// backoff returns the delay before the given retry (1 for the first retry),
+// with jitter spreading it between half and the full exponential delay.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < retry && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// do calls call until it succeeds, fails in a way that is not worth retrying
+// or runs out of attempts, waiting out the backoff in between
+func (p RetryPolicy) do(ctx context.Context, logger *log.Logger, apiMethod string, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+
+		if attempt >= p.MaxAttempts {
+			if attempt > 1 {
+				logger.Printf("Giving up on '%s' after %d attempts: %s\n", apiMethod, attempt, err)
+			}
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		logger.Printf("Call to '%s' failed (attempt %d of %d), retrying in %s: %s\n", apiMethod, attempt, p.MaxAttempts, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// isRetryable reports whether a failed call is worth sending again. Every
+// method we call is a read, so transient failures are retried; rejected
+// credentials or tokens never are, the session deals with those.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrAuthentication) {
+		return false
+	}
+
+	if errors.Is(err, ErrTransport) || errors.Is(err, ErrRateLimited) {
+		return true
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+
+	return false
+}
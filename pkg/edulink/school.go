@@ -0,0 +1,267 @@
+package edulink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/cache/common"
+)
+
+const (
+	DefaultProvisioningEndpoint = "https://provisioning.edulinkone.com/"
+
+	// DefaultSchoolTTL is how long a resolved school is kept, schools rarely
+	// move to another EduLink server
+	DefaultSchoolTTL = 7 * Day
+)
+
+// School is an EduLink school resolved from its school code
+type School struct {
+	// Code is the school code parents type into the EduLink app
+	Code string `json:"code"`
+
+	// Endpoint is the API URL of the school's EduLink server
+	Endpoint string `json:"endpoint"`
+
+	// EstablishmentID identifies the school on its EduLink server
+	EstablishmentID int `json:"establishment_id"`
+}
+
+// SchoolResolver turns a school code into the school's API endpoint and establishment
+type SchoolResolver interface {
+	Resolve(ctx context.Context, code string) (*School, error)
+}
+
+// StaticResolver resolves school codes from a fixed list, for tests and for
+// schools that are not in the provisioning service
+type StaticResolver map[string]School
+
+func (r StaticResolver) Resolve(ctx context.Context, code string) (*School, error) {
+	school, ok := r[strings.ToLower(code)]
+	if !ok {
+		return nil, fmt.Errorf("unknown school code %q", code)
+	}
+
+	school.Code = strings.ToLower(code)
+	return &school, nil
+}
+
+// ProvisioningResolver looks school codes up in the EduLink provisioning service
+type ProvisioningResolver struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+type ProvisioningResolverOptions struct {
+	// Endpoint defaults to DefaultProvisioningEndpoint
+	Endpoint string
+
+	// HTTPClient defaults to a client with a 10 second timeout
+	HTTPClient *http.Client
+}
+
+func NewProvisioningResolver(o *ProvisioningResolverOptions) *ProvisioningResolver {
+	r := &ProvisioningResolver{
+		endpoint:   o.Endpoint,
+		httpClient: o.HTTPClient,
+	}
+
+	if r.endpoint == "" {
+		r.endpoint = DefaultProvisioningEndpoint
+	}
+
+	if r.httpClient == nil {
+		r.httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return r
+}
+
+type SchoolFromCodeRequestParams struct {
+	Code string `json:"code"`
+}
+
+type SchoolFromCodeRequest struct {
+	RequestBase
+	Params SchoolFromCodeRequestParams `json:"params"`
+}
+
+type SchoolFromCodeResponse struct {
+	ResponseBase
+
+	Result struct {
+		ResultBase
+
+		School struct {
+			Server   string `json:"server"`
+			SchoolID int    `json:"school_id"`
+		} `json:"school"`
+	} `json:"result"`
+}
+
+func (r SchoolFromCodeRequest) GetBaseRequest() RequestBase {
+	return r.RequestBase
+}
+
+func (r SchoolFromCodeResponse) GetBaseResponse() ResponseBase {
+	return r.ResponseBase
+}
+
+func (r SchoolFromCodeResponse) GetBaseResult() ResultBase {
+	return r.Result.ResultBase
+}
+
+func (r *ProvisioningResolver) Resolve(ctx context.Context, code string) (*School, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	apiMethod := "School.FromCode"
+
+	body, err := json.Marshal(SchoolFromCodeRequest{
+		RequestBase: RequestBase{
+			ID:      1,
+			JsonRPC: "2.0",
+			Method:  apiMethod,
+		},
+		Params: SchoolFromCodeRequestParams{
+			Code: code,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s?method=%s", r.endpoint, apiMethod), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, &TransportError{Method: apiMethod, Err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &TransportError{Method: apiMethod, Err: err}
+	}
+
+	var response SchoolFromCodeResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, &TransportError{Method: apiMethod, Err: fmt.Errorf("decoding response: %w", err)}
+	}
+
+	if result := response.GetBaseResult(); !result.Success || response.Result.School.Server == "" {
+		return nil, &APIError{
+			Method:     apiMethod,
+			Message:    fmt.Sprintf("could not resolve school code %q: %s", code, result.Error),
+			UniqueID:   result.Metrics.UniqueID,
+			StatusCode: resp.StatusCode,
+		}
+	}
+
+	return &School{
+		Code:            code,
+		Endpoint:        response.Result.School.Server,
+		EstablishmentID: response.Result.School.SchoolID,
+	}, nil
+}
+
+// CachingResolver keeps the schools another resolver returns in a cache, so a
+// provisioning service outage does not stop schools resolved before from
+// being reported on. Failed lookups are retried like API calls.
+type CachingResolver struct {
+	resolver SchoolResolver
+	cache    *cache.Cache
+	ttl      time.Duration
+	retry    RetryPolicy
+	logger   *log.Logger
+}
+
+type CachingResolverOptions struct {
+	// Resolver looks up the schools that are not cached
+	Resolver SchoolResolver
+
+	// Cache stores the resolved schools, they are looked up every time when nil
+	Cache *cache.Cache
+
+	// TTL defaults to DefaultSchoolTTL
+	TTL time.Duration
+
+	// Retry controls retries of failed lookups, defaults to DefaultRetryPolicy
+	Retry *RetryPolicy
+
+	// Logger defaults to the standard logger
+	Logger *log.Logger
+}
+
+func NewCachingResolver(o *CachingResolverOptions) *CachingResolver {
+	r := &CachingResolver{
+		resolver: o.Resolver,
+		cache:    o.Cache,
+		ttl:      o.TTL,
+		retry:    DefaultRetryPolicy,
+		logger:   o.Logger,
+	}
+
+	if r.ttl == 0 {
+		r.ttl = DefaultSchoolTTL
+	}
+
+	if o.Retry != nil {
+		r.retry = *o.Retry
+	}
+
+	if r.logger == nil {
+		r.logger = log.Default()
+	}
+
+	return r
+}
+
+func (r *CachingResolver) Resolve(ctx context.Context, code string) (*School, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	key := fmt.Sprintf("school:%s", code)
+
+	if r.cache != nil && r.cache.Exists(ctx, key) {
+		school := &School{}
+		if err := r.cache.Get(ctx, key, school); err == nil {
+			return school, nil
+		}
+	}
+
+	var school *School
+	err := r.retry.do(ctx, r.logger, "School.FromCode", func() error {
+		var err error
+		school, err = r.resolver.Resolve(ctx, code)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if r.cache != nil {
+		err := r.cache.Set(&common.Item{
+			Ctx:   ctx,
+			Key:   key,
+			Value: school,
+			TTL:   r.ttl,
+
+			// schools are looked up again when evicted
+			Evictable: true,
+		})
+		if err != nil {
+			r.logger.Printf("Could not cache school %s: %s\n", code, err)
+		}
+	}
+
+	return school, nil
+}
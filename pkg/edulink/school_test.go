@@ -0,0 +1,110 @@
+package edulink_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/cache/common"
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/edulink/edulinktest"
+)
+
+var testRetry = &edulink.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+func newTestCache(t *testing.T) *cache.Cache {
+	t.Helper()
+
+	c := cache.New(&common.CacheOptions{CacheType: common.Local})
+	if err := c.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCachingResolver(t *testing.T) {
+	unavailable := edulinktest.Failure{StatusCode: http.StatusServiceUnavailable}
+
+	tests := []struct {
+		name string
+		code string
+		// failures are injected before the first and second lookups
+		first, second []edulinktest.Failure
+		wantErr       [2]bool
+		wantCalls     int
+	}{
+		{name: "cached", code: "edulinktest", wantCalls: 1},
+		{name: "code is trimmed and lowercased", code: " EduLinkTest ", wantCalls: 1},
+		{name: "retried", code: "edulinktest", first: []edulinktest.Failure{unavailable, {Drop: true}}, wantCalls: 3},
+		{name: "outage after it was resolved", code: "edulinktest", second: []edulinktest.Failure{unavailable, unavailable, unavailable}, wantCalls: 1},
+		{
+			name:      "outage before it was resolved",
+			code:      "edulinktest",
+			first:     []edulinktest.Failure{unavailable, unavailable, unavailable},
+			wantErr:   [2]bool{true, false},
+			wantCalls: 4,
+		},
+		{name: "unknown school is not retried", code: "unknown", wantErr: [2]bool{true, true}, wantCalls: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := edulinktest.NewServer(nil)
+			defer server.Close()
+
+			resolver := edulink.NewCachingResolver(&edulink.CachingResolverOptions{
+				Resolver: server.Resolver(),
+				Cache:    newTestCache(t),
+				Retry:    testRetry,
+				Logger:   log.New(io.Discard, "", 0),
+			})
+
+			for i, failures := range [][]edulinktest.Failure{test.first, test.second} {
+				for _, failure := range failures {
+					server.Fail("School.FromCode", failure, 1)
+				}
+
+				school, err := resolver.Resolve(context.Background(), test.code)
+				if test.wantErr[i] {
+					if err == nil {
+						t.Fatalf("lookup %d resolved %+v, want an error", i+1, school)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("lookup %d: %s", i+1, err)
+				}
+				if *school != *server.School() {
+					t.Errorf("lookup %d resolved %+v, want %+v", i+1, school, server.School())
+				}
+			}
+
+			if got := server.Calls("School.FromCode"); got != test.wantCalls {
+				t.Errorf("provisioning called %d times, want %d", got, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNewClientNeedsSchool(t *testing.T) {
+	tests := []struct {
+		name   string
+		school *edulink.School
+	}{
+		{name: "no school"},
+		{name: "no endpoint", school: &edulink.School{Code: "edulinktest", EstablishmentID: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := edulink.NewClient(&edulink.ClientOptions{School: test.school})
+			if err == nil {
+				t.Errorf("got a client for %+v, want an error", client.School())
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package edulink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/cache/common"
+)
+
+const DefaultSessionTTL = 30 * time.Minute
+
+// AuthenticatedRequest is a request that can carry an auth token. All
+// request types satisfy it when passed by pointer.
+type AuthenticatedRequest interface {
+	Request
+	SetAuthToken(authToken string)
+}
+
+func (r *RequestBase) SetAuthToken(authToken string) {
+	r.AuthToken = authToken
+}
+
+// Session logs in to EduLink lazily and reuses the auth token for as long as
+// EduLink accepts it. When a call fails because the token has expired, the
+// session logs in again once and replays the call.
+type Session struct {
+	options *SessionOptions
+
+	mu           sync.Mutex
+	login        *LoginResponse
+	expires      time.Time
+	expiredToken string
+
+	// loggingIn is closed when the login in flight finishes, nil when there
+	// is none
+	loggingIn chan struct{}
+}
+
+type SessionOptions struct {
+	Client   *Client
+	Username string
+	Password string
+
+	// Cache persists the login response between processes, optional
+	Cache *cache.Cache
+
+	// TTL is how long a login is reused before logging in again, defaults to DefaultSessionTTL
+	TTL time.Duration
+}
+
+func NewSession(o *SessionOptions) *Session {
+	if o.TTL == 0 {
+		o.TTL = DefaultSessionTTL
+	}
+
+	return &Session{
+		options: o,
+	}
+}
+
+func (s *Session) Client() *Client {
+	return s.options.Client
+}
+
+func (s *Session) Username() string {
+	return s.options.Username
+}
+
+func (s *Session) cacheKey() string {
+	return fmt.Sprintf("session:%s:%s", s.options.Client.School().Code, strings.ToLower(s.options.Username))
+}
+
+// Login returns the current login, logging in to EduLink if there is none
+// yet or it is older than the TTL. Concurrent callers wait for the same
+// login without holding the session locked.
+func (s *Session) Login(ctx context.Context) (*LoginResponse, error) {
+	for {
+		s.mu.Lock()
+		if s.login != nil && time.Now().Before(s.expires) {
+			login := s.login
+			s.mu.Unlock()
+			return login, nil
+		}
+
+		if loggingIn := s.loggingIn; loggingIn != nil {
+			s.mu.Unlock()
+			select {
+			case <-loggingIn:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		loggingIn := make(chan struct{})
+		s.loggingIn = loggingIn
+		s.login = nil
+		expiredToken := s.expiredToken
+		s.mu.Unlock()
+
+		login, expires, err := s.logIn(ctx, expiredToken)
+
+		s.mu.Lock()
+		if err == nil {
+			s.login, s.expires = login, expires
+		}
+		s.loggingIn = nil
+		close(loggingIn)
+		s.mu.Unlock()
+
+		return login, err
+	}
+}
+
+// logIn reuses the cached login unless it carries expiredToken, or logs in
+// to EduLink. It returns the login and when it is to be replaced.
+func (s *Session) logIn(ctx context.Context, expiredToken string) (*LoginResponse, time.Time, error) {
+	if s.options.Cache != nil && s.options.Cache.Exists(ctx, s.cacheKey()) {
+		var loginResponse LoginResponse
+		err := s.options.Cache.Get(ctx, s.cacheKey(), &loginResponse)
+		ttl, ttlErr := s.options.Cache.TTL(ctx, s.cacheKey())
+		if err == nil && ttlErr == nil && loginResponse.Result.AuthToken != "" && loginResponse.Result.AuthToken != expiredToken {
+			if ttl <= 0 || ttl > s.options.TTL {
+				ttl = s.options.TTL
+			}
+			s.options.Client.logger.Printf("Reusing cached session for %s\n", s.options.Username)
+			return &loginResponse, time.Now().Add(ttl), nil
+		}
+	}
+
+	loginReq := LoginRequest{
+		RequestBase: RequestBase{
+			ID:      1,
+			JsonRPC: "2.0",
+			Method:  "EduLink.Login",
+		},
+		Params: LoginRequestParams{
+			Username:        s.options.Username,
+			Password:        s.options.Password,
+			EstablishmentID: s.options.Client.EstablishmentID(),
+		},
+	}
+
+	loggedIn := time.Now()
+	var loginResponse LoginResponse
+	if err := s.options.Client.Call(ctx, loginReq, &loginResponse); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	s.options.Client.logger.Printf("Logged in to EduLink as %s\n", s.options.Username)
+
+	if s.options.Cache != nil {
+		s.options.Cache.Set(&common.Item{
+			Ctx:   ctx,
+			Key:   s.cacheKey(),
+			Value: loginResponse,
+			TTL:   s.options.TTL,
+
+			// evicting it only means logging in again
+			Evictable: true,
+		})
+	}
+
+	return &loginResponse, loggedIn.Add(s.options.TTL), nil
+}
+
+// invalidate forgets the login if it still carries the given auth token, so
+// concurrent callers that hit the same expired token only log in once.
+func (s *Session) invalidate(authToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.login != nil && s.login.Result.AuthToken == authToken {
+		s.login = nil
+		s.expiredToken = authToken
+	}
+}
+
+// Call sends an authenticated request, logging in again and replaying the
+// request once if EduLink reports that the auth token is no longer valid.
+func (s *Session) Call(ctx context.Context, body AuthenticatedRequest, response Result) error {
+	ctx = WithAccount(ctx, s.options.Username)
+
+	login, err := s.Login(ctx)
+	if err != nil {
+		return err
+	}
+
+	body.SetAuthToken(login.Result.AuthToken)
+	err = s.options.Client.Call(ctx, body, response)
+	if !errors.Is(err, ErrSessionExpired) {
+		return err
+	}
+
+	s.options.Client.logger.Printf("Session for %s expired, logging in again\n", s.options.Username)
+	s.invalidate(login.Result.AuthToken)
+
+	login, err = s.Login(ctx)
+	if err != nil {
+		return err
+	}
+
+	body.SetAuthToken(login.Result.AuthToken)
+	return s.options.Client.Call(ctx, body, response)
+}
@@ -0,0 +1,191 @@
+package edulink_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/edulink/edulinktest"
+)
+
+var sessionExpired = edulinktest.Failure{Message: "The session has expired or is invalid"}
+
+func newTestSession(t *testing.T, server *edulinktest.Server, c *cache.Cache, password string) *edulink.Session {
+	t.Helper()
+
+	return edulink.NewSession(&edulink.SessionOptions{
+		Client:   newTestClient(t, server),
+		Username: "parent",
+		Password: password,
+		Cache:    c,
+	})
+}
+
+func achievementRequest() *edulink.AchievementRequest {
+	return &edulink.AchievementRequest{
+		RequestBase: edulink.RequestBase{ID: 1, JsonRPC: "2.0", Method: "EduLink.Achievement"},
+		Params:      edulink.AchievementRequestParams{LearnerID: "1001", Format: 2},
+	}
+}
+
+func achievement(ctx context.Context, session *edulink.Session) error {
+	var response edulink.AchievementResponse
+	return session.Call(ctx, achievementRequest(), &response)
+}
+
+func TestSessionLogsInAgain(t *testing.T) {
+	tests := []struct {
+		name string
+		// expire runs between the first and second call
+		expire     func(server *edulinktest.Server)
+		wantErr    error
+		wantLogins int
+	}{
+		{name: "token is reused", expire: func(server *edulinktest.Server) {}, wantLogins: 1},
+		{name: "sessions expired", expire: (*edulinktest.Server).ExpireSessions, wantLogins: 2},
+		{
+			name:       "token rejected once",
+			expire:     func(server *edulinktest.Server) { server.Fail("EduLink.Achievement", sessionExpired, 1) },
+			wantLogins: 2,
+		},
+		{
+			name:       "token rejected after logging in again",
+			expire:     func(server *edulinktest.Server) { server.Fail("EduLink.Achievement", sessionExpired, 2) },
+			wantErr:    edulink.ErrSessionExpired,
+			wantLogins: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			server := edulinktest.NewServer(nil)
+			defer server.Close()
+
+			session := newTestSession(t, server, nil, "password")
+			if err := achievement(ctx, session); err != nil {
+				t.Fatal(err)
+			}
+
+			test.expire(server)
+			if err := achievement(ctx, session); !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+
+			if got := server.Calls("EduLink.Login"); got != test.wantLogins {
+				t.Errorf("logged in %d times, want %d", got, test.wantLogins)
+			}
+		})
+	}
+}
+
+func TestSessionCachedLogin(t *testing.T) {
+	ctx := context.Background()
+	server := edulinktest.NewServer(nil)
+	defer server.Close()
+
+	c := newTestCache(t)
+
+	steps := []struct {
+		name       string
+		expire     bool
+		wantLogins int
+	}{
+		{name: "first session logs in", wantLogins: 1},
+		{name: "next session reuses the cached login", wantLogins: 1},
+		{name: "cached login expired", expire: true, wantLogins: 2},
+		{name: "next session reuses the new login", wantLogins: 2},
+	}
+
+	for _, step := range steps {
+		if step.expire {
+			server.ExpireSessions()
+		}
+
+		if err := achievement(ctx, newTestSession(t, server, c, "password")); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+		if got := server.Calls("EduLink.Login"); got != step.wantLogins {
+			t.Errorf("%s: logged in %d times, want %d", step.name, got, step.wantLogins)
+		}
+	}
+}
+
+// the login kept in memory is replaced after the TTL, even while EduLink
+// still accepts its token
+func TestSessionTTL(t *testing.T) {
+	ctx := context.Background()
+	server := edulinktest.NewServer(nil)
+	defer server.Close()
+
+	session := edulink.NewSession(&edulink.SessionOptions{
+		Client:   newTestClient(t, server),
+		Username: "parent",
+		Password: "password",
+		TTL:      200 * time.Millisecond,
+	})
+
+	steps := []struct {
+		wait       time.Duration
+		wantLogins int
+	}{
+		{wantLogins: 1},
+		{wantLogins: 1},
+		{wait: 300 * time.Millisecond, wantLogins: 2},
+		{wantLogins: 2},
+	}
+	for i, step := range steps {
+		time.Sleep(step.wait)
+		if err := achievement(ctx, session); err != nil {
+			t.Fatalf("call %d: %s", i+1, err)
+		}
+		if got := server.Calls("EduLink.Login"); got != step.wantLogins {
+			t.Errorf("call %d: logged in %d times, want %d", i+1, got, step.wantLogins)
+		}
+	}
+}
+
+func TestSessionConcurrentLogin(t *testing.T) {
+	server := edulinktest.NewServer(nil)
+	defer server.Close()
+
+	session := newTestSession(t, server, nil, "password")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := achievement(context.Background(), session); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := server.Calls("EduLink.Login"); got != 1 {
+		t.Errorf("logged in %d times, want 1", got)
+	}
+}
+
+func TestSessionWrongPassword(t *testing.T) {
+	server := edulinktest.NewServer(nil)
+	defer server.Close()
+
+	err := achievement(context.Background(), newTestSession(t, server, nil, "wrong"))
+	if !errors.Is(err, edulink.ErrAuthentication) || errors.Is(err, edulink.ErrSessionExpired) {
+		t.Errorf("got %v, want ErrAuthentication", err)
+	}
+
+	// rejected credentials are not retried
+	if got := server.Calls("EduLink.Login"); got != 1 {
+		t.Errorf("logged in %d times, want 1", got)
+	}
+	if got := server.Calls("EduLink.Achievement"); got != 0 {
+		t.Errorf("called EduLink.Achievement %d times without a login", got)
+	}
+}
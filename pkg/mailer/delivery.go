@@ -0,0 +1,213 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/cache/common"
+)
+
+const (
+	// DefaultDeliveriesPerChild is the number of sent reports remembered per child
+	DefaultDeliveriesPerChild = 20
+
+	deliveryTTL = 90 * 24 * time.Hour
+)
+
+// DeliveryEvent is something that happened to a sent message, as reported by
+// the Mailgun webhooks
+type DeliveryEvent struct {
+	// Event is delivered, failed, complained or opened
+	Event     string
+	Recipient string
+	Time      time.Time
+
+	// Severity is permanent or temporary for failed events
+	Severity string
+	Reason   string
+}
+
+// DeliveryStatus is a sent report and the events received for it
+type DeliveryStatus struct {
+	MessageID  string
+	Subject    string
+	Recipients []string
+	SentAt     time.Time
+
+	School         string
+	ChildID        string
+	AchievementIDs []string
+	BehaviourIDs   []string
+
+	Events []DeliveryEvent
+}
+
+// Status summarises the events, the most telling first: complained, failed,
+// opened, delivered and, without events, sent
+func (d *DeliveryStatus) Status() string {
+	rank := map[string]int{"delivered": 1, "opened": 2, "failed": 3, "complained": 4}
+
+	status := "sent"
+	for _, event := range d.Events {
+		name := event.Event
+		// temporary failures are retried by Mailgun, they are not the final word
+		if name == "failed" && event.Severity == "temporary" {
+			continue
+		}
+		if rank[name] > rank[status] {
+			status = name
+		}
+	}
+	return status
+}
+
+// Reason explains the latest failure or complaint, empty when there is none
+func (d *DeliveryStatus) Reason() string {
+	reason := ""
+	for _, event := range d.Events {
+		if event.Event == "failed" || event.Event == "complained" {
+			reason = fmt.Sprintf("%s: %s", event.Recipient, event.Reason)
+		}
+	}
+	return reason
+}
+
+// DeliveryLog remembers the messages sent for each child and the delivery
+// events Mailgun reports for them
+type DeliveryLog struct {
+	cache    *cache.Cache
+	perChild int
+
+	mu sync.Mutex
+}
+
+type DeliveryLogOptions struct {
+	Cache *cache.Cache
+
+	// PerChild defaults to DefaultDeliveriesPerChild
+	PerChild int
+}
+
+func NewDeliveryLog(o *DeliveryLogOptions) *DeliveryLog {
+	perChild := o.PerChild
+	if perChild == 0 {
+		perChild = DefaultDeliveriesPerChild
+	}
+
+	return &DeliveryLog{
+		cache:    o.Cache,
+		perChild: perChild,
+	}
+}
+
+// normaliseMessageID drops the angle brackets, Mailgun leaves them out of
+// its events but returns them from the API
+func normaliseMessageID(messageID string) string {
+	return strings.Trim(strings.TrimSpace(messageID), "<>")
+}
+
+func (l *DeliveryLog) statusKey(messageID string) string {
+	return fmt.Sprintf("delivery:%s", normaliseMessageID(messageID))
+}
+
+func (l *DeliveryLog) childKey(school string, childID string) string {
+	return fmt.Sprintf("deliveries:%s:%s", school, childID)
+}
+
+func (l *DeliveryLog) set(ctx context.Context, key string, value interface{}) error {
+	return l.cache.Set(&common.Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: value,
+		TTL:   deliveryTTL,
+	})
+}
+
+// RecordSent remembers a message the outbox sent, so events can be matched
+// to the report it carried
+func (l *DeliveryLog) RecordSent(ctx context.Context, school string, entry *OutboxEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	status := &DeliveryStatus{}
+	key := l.statusKey(entry.Message.MessageID)
+	if l.cache.Exists(ctx, key) {
+		// events can arrive before the send is recorded
+		if err := l.cache.Get(ctx, key, status); err != nil {
+			return err
+		}
+	}
+
+	status.MessageID = normaliseMessageID(entry.Message.MessageID)
+	status.Subject = entry.Message.Subject
+	status.Recipients = entry.Message.Recipients()
+	status.SentAt = time.Now()
+	status.School = school
+	status.ChildID = entry.ChildID
+	status.AchievementIDs = entry.AchievementIDs
+	status.BehaviourIDs = entry.BehaviourIDs
+
+	if err := l.set(ctx, key, status); err != nil {
+		return err
+	}
+
+	messageIDs := []string{}
+	childKey := l.childKey(school, entry.ChildID)
+	if l.cache.Exists(ctx, childKey) {
+		if err := l.cache.Get(ctx, childKey, &messageIDs); err != nil {
+			return err
+		}
+	}
+
+	messageIDs = append([]string{status.MessageID}, messageIDs...)
+	if len(messageIDs) > l.perChild {
+		messageIDs = messageIDs[:l.perChild]
+	}
+
+	return l.set(ctx, childKey, messageIDs)
+}
+
+// RecordEvent adds a webhook event to the status of the message
+func (l *DeliveryLog) RecordEvent(ctx context.Context, messageID string, event DeliveryEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	status := &DeliveryStatus{MessageID: normaliseMessageID(messageID)}
+	key := l.statusKey(messageID)
+	if l.cache.Exists(ctx, key) {
+		if err := l.cache.Get(ctx, key, status); err != nil {
+			return err
+		}
+	}
+
+	status.Events = append(status.Events, event)
+	return l.set(ctx, key, status)
+}
+
+// ForChild returns the latest messages sent for a child, newest first
+func (l *DeliveryLog) ForChild(ctx context.Context, school string, childID string) ([]*DeliveryStatus, error) {
+	messageIDs := []string{}
+	childKey := l.childKey(school, childID)
+	if !l.cache.Exists(ctx, childKey) {
+		return nil, nil
+	}
+
+	if err := l.cache.Get(ctx, childKey, &messageIDs); err != nil {
+		return nil, err
+	}
+
+	statuses := []*DeliveryStatus{}
+	for _, messageID := range messageIDs {
+		status := &DeliveryStatus{}
+		if err := l.cache.Get(ctx, l.statusKey(messageID), status); err != nil {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
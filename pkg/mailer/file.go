@@ -0,0 +1,147 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type FileFormat string
+
+const (
+	// FileFormatEml writes every message as <time>-<subject>.eml into Dir
+	FileFormatEml FileFormat = "eml"
+	// FileFormatMaildir delivers every message into the Maildir at Dir
+	FileFormatMaildir FileFormat = "maildir"
+)
+
+// FileTransport writes messages to disk instead of sending them, so they can
+// be opened in any mail client
+type FileTransport struct {
+	dir    string
+	format FileFormat
+}
+
+type FileTransportOptions struct {
+	Dir string
+
+	// Format defaults to FileFormatEml
+	Format FileFormat
+}
+
+func NewFileTransport(o *FileTransportOptions) (*FileTransport, error) {
+	if o.Dir == "" {
+		return nil, errors.New("file: directory is required")
+	}
+
+	format := o.Format
+	if format == "" {
+		format = FileFormatEml
+	}
+
+	switch format {
+	case FileFormatEml:
+		if err := os.MkdirAll(o.Dir, 0o755); err != nil {
+			return nil, err
+		}
+	case FileFormatMaildir:
+		for _, sub := range []string{"tmp", "new", "cur"} {
+			if err := os.MkdirAll(filepath.Join(o.Dir, sub), 0o700); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("file: unknown format %q, expected eml or maildir", format)
+	}
+
+	return &FileTransport{dir: o.Dir, format: format}, nil
+}
+
+// Path returns the directory messages are written to
+func (t *FileTransport) Path() string {
+	return t.dir
+}
+
+func (t *FileTransport) Send(ctx context.Context, m *Message) (string, error) {
+	if m.MessageID == "" {
+		m.MessageID = newMessageID(domainOf(m.From))
+	}
+
+	// nothing goes over the wire, the file shows every recipient
+	header := textproto.MIMEHeader{}
+	if len(m.BCC) > 0 {
+		header.Set("Bcc", strings.Join(m.BCC, ", "))
+	}
+
+	data, err := m.bytes(header)
+	if err != nil {
+		return "", err
+	}
+
+	var path string
+	if t.format == FileFormatMaildir {
+		path, err = t.deliverMaildir(data)
+	} else {
+		path, err = t.writeEml(m, data)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("Wrote %s to %s\n", m.MessageID, path)
+	return m.MessageID, nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func (t *FileTransport) writeEml(m *Message, data []byte) (string, error) {
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(m.Subject, "-"), "-")
+	if len(name) > 60 {
+		name = name[:60]
+	}
+
+	path := filepath.Join(t.dir, fmt.Sprintf("%s-%s-%s.eml", time.Now().Format("20060102-150405"), name, uniqueSuffix()))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// deliverMaildir writes into tmp and renames into new, so readers never see a
+// partially written message
+func (t *FileTransport) deliverMaildir(data []byte) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	hostname = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname)
+
+	name := fmt.Sprintf("%d.%d_%s.%s", time.Now().Unix(), os.Getpid(), uniqueSuffix(), hostname)
+	tmp := filepath.Join(t.dir, "tmp", name)
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(t.dir, "new", name)
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return path, nil
+}
+
+func uniqueSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
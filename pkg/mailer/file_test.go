@@ -0,0 +1,74 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileTransportShowsBcc(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  FileFormat
+		pattern string
+	}{
+		{name: "eml", format: FileFormatEml, pattern: "*.eml"},
+		{name: "maildir", format: FileFormatMaildir, pattern: filepath.Join("new", "*")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			transport, err := NewFileTransport(&FileTransportOptions{Dir: dir, Format: test.format})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			message := &Message{
+				From:    "reports@example.com",
+				To:      []string{"parent@example.com"},
+				BCC:     []string{"archive@example.com", "tutor@example.com"},
+				Subject: "Report",
+				HTML:    "<p>Report</p>",
+			}
+			if _, err := transport.Send(context.Background(), message); err != nil {
+				t.Fatal(err)
+			}
+
+			paths, _ := filepath.Glob(filepath.Join(dir, test.pattern))
+			if len(paths) != 1 {
+				t.Fatalf("wrote %d files, want 1", len(paths))
+			}
+			data, err := os.ReadFile(paths[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Contains(data, []byte("\r\nBcc: archive@example.com, tutor@example.com\r\n")) {
+				t.Errorf("written message has no Bcc header:\n%s", data)
+			}
+		})
+	}
+}
+
+func TestMessageBytesHidesBcc(t *testing.T) {
+	message := &Message{
+		From: "reports@example.com",
+		BCC:  []string{"archive@example.com"},
+		HTML: "<p>Report</p>",
+	}
+
+	data, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(data, []byte("archive@example.com")) {
+		t.Errorf("message shows its BCC recipient:\n%s", data)
+	}
+	if !bytes.Contains(data, []byte("To: undisclosed-recipients:;\r\n")) {
+		t.Errorf("BCC only message should be to undisclosed recipients:\n%s", data)
+	}
+}
@@ -3,19 +3,20 @@ package mailer
 import (
 	"context"
 	"fmt"
-	"html/template"
 	"log"
-	"os"
-	"strings"
+	"text/template"
 	"time"
 
 	"github.com/eu-evops/edulink/pkg/edulink"
-	"github.com/mailgun/mailgun-go/v4"
 )
 
+const DefaultDomain = "evops.eu"
+
 type Mailer struct {
-	mailgunApiKey string
-	mailGun       *mailgun.MailgunImpl
+	transport Transport
+	from      string
+	replyTo   string
+	subject   *template.Template
 
 	teachers      []edulink.Employee
 	teacherPhotos []edulink.TeacherPhoto
@@ -25,8 +26,23 @@ type Mailer struct {
 }
 
 type MailerOptions struct {
+	// Transport delivers the reports, defaults to Mailgun with MailgunApiKey
+	Transport     Transport
 	MailgunApiKey string
 
+	// Domain is the Mailgun sending domain, defaults to DefaultDomain
+	Domain string
+
+	// From defaults to EduLink <edulink@Domain>
+	From string
+
+	// ReplyTo is left out of the message when empty
+	ReplyTo string
+
+	// Subject is executed against the SchoolReport, see ParseSubject.
+	// Defaults to DefaultSubject.
+	Subject *template.Template
+
 	Teachers         []edulink.Employee
 	TeacherPhotos    []edulink.TeacherPhoto
 	BehaviourTypes   []edulink.BehaviourType
@@ -34,9 +50,34 @@ type MailerOptions struct {
 }
 
 func NewMailer(o *MailerOptions) *Mailer {
+	domain := o.Domain
+	if domain == "" {
+		domain = DefaultDomain
+	}
+
+	transport := o.Transport
+	if transport == nil {
+		transport = NewMailgunTransport(&MailgunTransportOptions{
+			Domain: domain,
+			ApiKey: o.MailgunApiKey,
+		})
+	}
+
+	from := o.From
+	if from == "" {
+		from = fmt.Sprintf("EduLink <edulink@%s>", domain)
+	}
+
+	subject := o.Subject
+	if subject == nil {
+		subject = template.Must(ParseSubject(DefaultSubject))
+	}
+
 	return &Mailer{
-		mailgunApiKey:    o.MailgunApiKey,
-		mailGun:          mailgun.NewMailgun("evops.eu", o.MailgunApiKey),
+		transport:        transport,
+		from:             from,
+		replyTo:          o.ReplyTo,
+		subject:          subject,
 		teachers:         o.Teachers,
 		teacherPhotos:    o.TeacherPhotos,
 		behaviourTypes:   o.BehaviourTypes,
@@ -44,33 +85,66 @@ func NewMailer(o *MailerOptions) *Mailer {
 	}
 }
 
-func (m *Mailer) Send(schoolReport *edulink.SchoolReport, mail string) {
-	sender := "EduLink <edulink@evops.eu>"
-	subject := fmt.Sprintf("EduLink School Report: %s", schoolReport.Child.Forename)
+// Recipients are the addresses a report is sent to, BCC recipients are left
+// out of the headers
+type Recipients struct {
+	To  []string
+	CC  []string
+	BCC []string
+}
 
-	recipients := os.Getenv("EMAIL_RECIPIENTS")
-	if recipients == "" {
-		fmt.Println("No recipients specified, skipping email")
-		return
+func (r Recipients) Empty() bool {
+	return len(r.To)+len(r.CC)+len(r.BCC) == 0
+}
+
+// Compose builds the message of a report rendered as HTML, with an optional
+// plain text alternative
+func (m *Mailer) Compose(schoolReport *edulink.SchoolReport, mail string, text string, recipients Recipients) *Message {
+	subject, err := renderSubject(m.subject, schoolReport)
+	if err != nil {
+		log.Printf("Could not render subject, using the default: %s\n", err)
+		subject, _ = renderSubject(template.Must(ParseSubject(DefaultSubject)), schoolReport)
 	}
 
-	recipientsList := strings.Split(recipients, ",")
+	return &Message{
+		From:    m.from,
+		ReplyTo: m.replyTo,
+		To:      recipients.To,
+		CC:      recipients.CC,
+		BCC:     recipients.BCC,
+		Subject: subject,
+		HTML:    mail,
+		Text:    text,
+
+		// photos go inline, mail clients strip data: URIs
+		Inline: schoolReport.InlineImages(),
+	}
+}
 
-	message := m.mailGun.NewMessage(sender, subject, "html", recipientsList...)
+// Transport returns the transport messages are sent with
+func (m *Mailer) Transport() Transport {
+	return m.transport
+}
 
-	template.New("schoolReport")
+// Send composes and sends a report straight away, see Outbox for delivery
+// with retries
+func (m *Mailer) Send(schoolReport *edulink.SchoolReport, mail string, text string, recipients Recipients) (string, error) {
+	if recipients.Empty() {
+		fmt.Println("No recipients specified, skipping email")
+		return "", nil
+	}
 
-	message.SetHtml(mail)
+	message := m.Compose(schoolReport, mail, text, recipients)
 
+	// Send the message with a 10 second timeout
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
-	// Send the message with a 10 second timeout
-	resp, id, err := m.mailGun.Send(ctx, message)
-
+	id, err := m.transport.Send(ctx, message)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
-	fmt.Printf("ID: %s Resp: %s\n", id, resp)
+	fmt.Printf("ID: %s\n", id)
+	return id, nil
 }
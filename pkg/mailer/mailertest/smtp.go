@@ -0,0 +1,357 @@
+// Package mailertest provides an in-process SMTP server to test mail
+// transports against.
+package mailertest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/mailer"
+)
+
+// ReceivedMessage is a message accepted by the SMTP server
+type ReceivedMessage struct {
+	From string
+	To   []string
+	Data []byte
+
+	// Username is the user the client authenticated as, if any
+	Username string
+
+	// TLS tells whether the message was sent over an encrypted connection
+	TLS bool
+}
+
+type SMTPServerOptions struct {
+	// Security is how clients are expected to connect, defaults to mailer.SMTPStartTLS
+	Security mailer.SMTPSecurity
+
+	// Username and Password are required from clients when set
+	Username string
+	Password string
+}
+
+// SMTPServer accepts mail on a local port and keeps it in memory
+type SMTPServer struct {
+	options   *SMTPServerOptions
+	listener  net.Listener
+	tlsConfig *tls.Config
+	certPool  *x509.CertPool
+
+	mu       sync.Mutex
+	messages []ReceivedMessage
+	wg       sync.WaitGroup
+}
+
+// NewSMTPServer starts an SMTP server on 127.0.0.1 with a self-signed
+// certificate, trusted by ClientTLSConfig. Close it when done.
+func NewSMTPServer(o *SMTPServerOptions) (*SMTPServer, error) {
+	if o.Security == "" {
+		o.Security = mailer.SMTPStartTLS
+	}
+
+	certificate, certPool, err := selfSignedCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SMTPServer{
+		options:   o,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{certificate}},
+		certPool:  certPool,
+	}
+
+	if o.Security == mailer.SMTPImplicitTLS {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+func (s *SMTPServer) Host() string {
+	return "127.0.0.1"
+}
+
+func (s *SMTPServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// TransportOptions returns SMTP transport options pointing at the server
+func (s *SMTPServer) TransportOptions() *mailer.SMTPTransportOptions {
+	return &mailer.SMTPTransportOptions{
+		Host:      s.Host(),
+		Port:      s.Port(),
+		Security:  s.options.Security,
+		Username:  s.options.Username,
+		Password:  s.options.Password,
+		TLSConfig: s.ClientTLSConfig(),
+	}
+}
+
+// ClientTLSConfig trusts the server's self-signed certificate
+func (s *SMTPServer) ClientTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: s.certPool}
+}
+
+// Messages returns the messages received so far
+func (s *SMTPServer) Messages() []ReceivedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]ReceivedMessage{}, s.messages...)
+}
+
+func (s *SMTPServer) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *SMTPServer) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+
+			conn.SetDeadline(time.Now().Add(30 * time.Second))
+			s.handle(conn)
+		}()
+	}
+}
+
+type session struct {
+	conn     net.Conn
+	text     *textproto.Conn
+	tls      bool
+	username string
+	from     string
+	to       []string
+}
+
+func (s *SMTPServer) handle(conn net.Conn) {
+	sess := &session{
+		conn: conn,
+		text: textproto.NewConn(conn),
+		tls:  s.options.Security == mailer.SMTPImplicitTLS,
+	}
+
+	sess.text.PrintfLine("220 localhost ESMTP mailertest")
+
+	for {
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			sess.text.PrintfLine("250 localhost")
+		case "EHLO":
+			s.ehlo(sess)
+		case "STARTTLS":
+			if sess.tls || s.options.Security != mailer.SMTPStartTLS {
+				sess.text.PrintfLine("502 STARTTLS not available")
+				continue
+			}
+			sess.text.PrintfLine("220 Ready to start TLS")
+
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			sess.conn = tlsConn
+			sess.text = textproto.NewConn(tlsConn)
+			sess.tls = true
+		case "AUTH":
+			s.auth(sess, arg)
+		case "MAIL":
+			if s.options.Username != "" && sess.username == "" {
+				sess.text.PrintfLine("530 Authentication required")
+				continue
+			}
+			sess.from = trimPath(arg, "FROM:")
+			sess.to = nil
+			sess.text.PrintfLine("250 OK")
+		case "RCPT":
+			if sess.from == "" {
+				sess.text.PrintfLine("503 MAIL first")
+				continue
+			}
+			sess.to = append(sess.to, trimPath(arg, "TO:"))
+			sess.text.PrintfLine("250 OK")
+		case "DATA":
+			if len(sess.to) == 0 {
+				sess.text.PrintfLine("503 RCPT first")
+				continue
+			}
+			sess.text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+
+			data, err := io.ReadAll(sess.text.DotReader())
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.messages = append(s.messages, ReceivedMessage{
+				From:     sess.from,
+				To:       sess.to,
+				Data:     data,
+				Username: sess.username,
+				TLS:      sess.tls,
+			})
+			s.mu.Unlock()
+
+			sess.from, sess.to = "", nil
+			sess.text.PrintfLine("250 OK queued")
+		case "RSET":
+			sess.from, sess.to = "", nil
+			sess.text.PrintfLine("250 OK")
+		case "NOOP":
+			sess.text.PrintfLine("250 OK")
+		case "QUIT":
+			sess.text.PrintfLine("221 Bye")
+			return
+		default:
+			sess.text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *SMTPServer) ehlo(sess *session) {
+	extensions := []string{"localhost", "8BITMIME"}
+	if s.options.Security == mailer.SMTPStartTLS && !sess.tls {
+		extensions = append(extensions, "STARTTLS")
+	}
+	if s.options.Username != "" && (sess.tls || s.options.Security == mailer.SMTPNone) {
+		extensions = append(extensions, "AUTH PLAIN LOGIN")
+	}
+
+	for i, extension := range extensions {
+		separator := "-"
+		if i == len(extensions)-1 {
+			separator = " "
+		}
+		sess.text.PrintfLine("250%s%s", separator, extension)
+	}
+}
+
+func (s *SMTPServer) auth(sess *session, arg string) {
+	if s.options.Username == "" || (!sess.tls && s.options.Security != mailer.SMTPNone) {
+		sess.text.PrintfLine("503 AUTH not available")
+		return
+	}
+
+	mechanism, initial, _ := strings.Cut(arg, " ")
+
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		if initial == "" {
+			sess.text.PrintfLine("334 ")
+			initial, _ = sess.text.ReadLine()
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(initial)
+		parts := strings.Split(string(decoded), "\x00")
+		if err != nil || len(parts) != 3 {
+			sess.text.PrintfLine("501 Malformed AUTH PLAIN")
+			return
+		}
+		username, password = parts[1], parts[2]
+	case "LOGIN":
+		username = challenge(sess, "Username:")
+		password = challenge(sess, "Password:")
+	default:
+		sess.text.PrintfLine("504 Unrecognized authentication type")
+		return
+	}
+
+	if username != s.options.Username || password != s.options.Password {
+		sess.text.PrintfLine("535 Authentication credentials invalid")
+		return
+	}
+
+	sess.username = username
+	sess.text.PrintfLine("235 Authentication successful")
+}
+
+func challenge(sess *session, prompt string) string {
+	sess.text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+	line, _ := sess.text.ReadLine()
+	decoded, _ := base64.StdEncoding.DecodeString(line)
+	return string(decoded)
+}
+
+// trimPath turns "FROM:<a@b.c> SIZE=10" into "a@b.c"
+func trimPath(arg string, prefix string) string {
+	arg = strings.TrimSpace(arg)
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	arg, _, _ = strings.Cut(strings.TrimSpace(arg), " ")
+	return strings.Trim(arg, "<>")
+}
+
+func selfSignedCertificate() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mailertest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: certificate}, pool, nil
+}
@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+
+	"github.com/mailgun/mailgun-go/v4"
+)
+
+// MailgunTransport sends messages through the Mailgun API
+type MailgunTransport struct {
+	mailGun *mailgun.MailgunImpl
+}
+
+type MailgunTransportOptions struct {
+	Domain string
+	ApiKey string
+}
+
+func NewMailgunTransport(o *MailgunTransportOptions) *MailgunTransport {
+	return &MailgunTransport{
+		mailGun: mailgun.NewMailgun(o.Domain, o.ApiKey),
+	}
+}
+
+func (t *MailgunTransport) Send(ctx context.Context, m *Message) (string, error) {
+	// Mailgun needs a To recipient, BCC only messages are addressed to the sender
+	to := m.To
+	if len(to) == 0 {
+		to = []string{m.From}
+	}
+
+	// Mailgun sends the text and HTML as multipart/alternative
+	message := t.mailGun.NewMessage(m.From, m.Subject, m.Text, to...)
+	message.SetHtml(m.HTML)
+	for _, cc := range m.CC {
+		message.AddCC(cc)
+	}
+	for _, bcc := range m.BCC {
+		message.AddBCC(bcc)
+	}
+	if m.ReplyTo != "" {
+		message.SetReplyTo(m.ReplyTo)
+	}
+	// keeps the outbox's deterministic Message-ID, Mailgun would make one up
+	if m.MessageID != "" {
+		message.AddHeader("Message-Id", m.MessageID)
+	}
+
+	for _, image := range m.Inline {
+		message.AddReaderInline(image.ContentID, io.NopCloser(bytes.NewReader(image.Data)))
+	}
+
+	resp, id, err := t.mailGun.Send(ctx, message)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Mailgun accepted %s: %s\n", id, resp)
+	if m.MessageID == "" {
+		m.MessageID = id
+	}
+	return id, nil
+}
@@ -0,0 +1,226 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+)
+
+// Message is a rendered report email, ready to be handed to a Transport
+type Message struct {
+	// MessageID is set by the transport when empty
+	MessageID string
+
+	From    string
+	ReplyTo string
+	To      []string
+	CC      []string
+	BCC     []string
+	Subject string
+	HTML    string
+
+	// Text is the plain text alternative to HTML, left out when empty
+	Text string
+
+	// Inline images are referenced from HTML as cid:ContentID
+	Inline []edulink.InlineImage
+}
+
+// Recipients returns every address the message is delivered to
+func (m *Message) Recipients() []string {
+	recipients := append([]string{}, m.To...)
+	recipients = append(recipients, m.CC...)
+	return append(recipients, m.BCC...)
+}
+
+func newMessageID(domain string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
+
+// Bytes renders the message in RFC 5322 format with a MIME body. BCC
+// recipients are left out, as they are on the wire.
+func (m *Message) Bytes() ([]byte, error) {
+	return m.bytes(nil)
+}
+
+// bytes renders the message with extra header fields
+func (m *Message) bytes(extra textproto.MIMEHeader) ([]byte, error) {
+	var buf bytes.Buffer
+
+	header := textproto.MIMEHeader{}
+	for key, values := range extra {
+		header[key] = values
+	}
+	header.Set("From", m.From)
+	if len(m.To) > 0 {
+		header.Set("To", strings.Join(m.To, ", "))
+	} else {
+		header.Set("To", "undisclosed-recipients:;")
+	}
+	if len(m.CC) > 0 {
+		header.Set("Cc", strings.Join(m.CC, ", "))
+	}
+	if m.ReplyTo != "" {
+		header.Set("Reply-To", m.ReplyTo)
+	}
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	if m.MessageID != "" {
+		header.Set("Message-ID", m.MessageID)
+	}
+	header.Set("MIME-Version", "1.0")
+
+	body, err := m.body()
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range body.header {
+		header[key] = values
+	}
+	writeHeader(&buf, header)
+	buf.Write(body.data)
+
+	return buf.Bytes(), nil
+}
+
+// part is an encoded MIME entity, either a leaf or a nested multipart
+type part struct {
+	header textproto.MIMEHeader
+	data   []byte
+}
+
+// body returns the HTML, alongside the plain text as multipart/alternative
+// when there is one
+func (m *Message) body() (*part, error) {
+	html, err := m.htmlPart()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Text == "" {
+		return html, nil
+	}
+
+	text, err := textPart("text/plain", m.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	// clients show the last alternative they understand, so the HTML goes last
+	return multipartOf("multipart/alternative", "", text, html)
+}
+
+// htmlPart returns the HTML, inside multipart/related with the inline images
+// when there are any
+func (m *Message) htmlPart() (*part, error) {
+	html, err := textPart("text/html", m.HTML)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.Inline) == 0 {
+		return html, nil
+	}
+
+	parts := []*part{html}
+	for _, image := range m.Inline {
+		var data bytes.Buffer
+		writeBase64(&data, image.Data)
+
+		parts = append(parts, &part{
+			header: textproto.MIMEHeader{
+				"Content-Type":              {fmt.Sprintf(`%s; name="%s"`, image.ContentType, image.ContentID)},
+				"Content-Transfer-Encoding": {"base64"},
+				"Content-Disposition":       {fmt.Sprintf(`inline; filename="%s"`, image.ContentID)},
+				"Content-ID":                {fmt.Sprintf("<%s>", image.ContentID)},
+			},
+			data: data.Bytes(),
+		})
+	}
+
+	return multipartOf("multipart/related", `type="text/html"; `, parts...)
+}
+
+func textPart(contentType string, text string) (*part, error) {
+	var data bytes.Buffer
+	if err := writeQuotedPrintable(&data, text); err != nil {
+		return nil, err
+	}
+
+	return &part{
+		header: textproto.MIMEHeader{
+			"Content-Type":              {fmt.Sprintf(`%s; charset="utf-8"`, contentType)},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		},
+		data: data.Bytes(),
+	}, nil
+}
+
+func multipartOf(contentType string, params string, parts ...*part) (*part, error) {
+	var data bytes.Buffer
+	writer := multipart.NewWriter(&data)
+
+	for _, p := range parts {
+		w, err := writer.CreatePart(p.header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(p.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return &part{
+		header: textproto.MIMEHeader{
+			"Content-Type": {fmt.Sprintf(`%s; %sboundary="%s"`, contentType, params, writer.Boundary())},
+		},
+		data: data.Bytes(),
+	}, nil
+}
+
+// headerOrder keeps the top level headers in the order mail clients show them
+var headerOrder = []string{"From", "To", "Cc", "Bcc", "Reply-To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"}
+
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	for _, key := range headerOrder {
+		for _, value := range header[textproto.CanonicalMIMEHeaderKey(key)] {
+			fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(text)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
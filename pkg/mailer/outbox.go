@@ -0,0 +1,447 @@
+package mailer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/cache/common"
+	"github.com/eu-evops/edulink/pkg/edulink"
+)
+
+const (
+	DefaultOutboxMaxAttempts = 4
+	DefaultOutboxBackoff     = 2 * time.Second
+	DefaultOutboxMaxAge      = 7 * 24 * time.Hour
+
+	// sent keys are remembered long enough for the items to have been marked seen
+	outboxSentTTL = 90 * 24 * time.Hour
+	outboxTTL     = 10 * 365 * 24 * time.Hour
+)
+
+// ErrAlreadySent is returned by Outbox.Enqueue for an entry whose key has
+// been delivered before
+var ErrAlreadySent = errors.New("outbox: already sent")
+
+// OutboxEntry is a message waiting in the outbox, with the report items it
+// covers
+type OutboxEntry struct {
+	// Key identifies the entry across runs, see NewOutboxEntry
+	Key     string
+	Message *Message
+
+	ChildID        string
+	AchievementIDs []string
+	BehaviourIDs   []string
+
+	EnqueuedAt time.Time
+	Attempts   int
+	LastError  string
+}
+
+// NewOutboxEntry wraps a rendered report. Its key is derived from the child,
+// the reported items and the recipients, so the same report to the same
+// people always gets the same key.
+func NewOutboxEntry(schoolReport *edulink.SchoolReport, message *Message) *OutboxEntry {
+	entry := &OutboxEntry{
+		Message:        message,
+		ChildID:        schoolReport.Child.ID,
+		AchievementIDs: schoolReport.AchievementIDs(),
+		BehaviourIDs:   schoolReport.BehaviourIDs(),
+		EnqueuedAt:     time.Now(),
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "child:%s\n", entry.ChildID)
+	fmt.Fprintf(hash, "achievements:%s\n", sortedJoin(entry.AchievementIDs))
+	fmt.Fprintf(hash, "behaviours:%s\n", sortedJoin(entry.BehaviourIDs))
+	fmt.Fprintf(hash, "to:%s\n", sortedJoin(message.To))
+	fmt.Fprintf(hash, "cc:%s\n", sortedJoin(message.CC))
+	fmt.Fprintf(hash, "bcc:%s\n", sortedJoin(message.BCC))
+	entry.Key = hex.EncodeToString(hash.Sum(nil))[:32]
+
+	// the same key gives the same Message-ID, so receivers can spot duplicates
+	if message.MessageID == "" {
+		message.MessageID = fmt.Sprintf("<%s@%s>", entry.Key, domainOf(message.From))
+	}
+
+	return entry
+}
+
+func sortedJoin(values []string) string {
+	sorted := []string{}
+	for _, value := range values {
+		sorted = append(sorted, strings.ToLower(strings.TrimSpace(value)))
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// Outbox holds messages until the transport accepts them. Entries are stored
+// in the cache, so messages that could not be sent are retried by the next
+// run.
+type Outbox struct {
+	cache     *cache.Cache
+	transport Transport
+
+	maxAttempts int
+	backoff     time.Duration
+	maxAge      time.Duration
+
+	// memory holds the entries when there is no cache
+	mu     sync.Mutex
+	memory []*OutboxEntry
+}
+
+type OutboxOptions struct {
+	// Cache stores the entries, they are only kept in memory when nil.
+	// Accounts sharing a cache each use one scoped to them, see
+	// cache.WithPrefix.
+	Cache *cache.Cache
+
+	Transport Transport
+
+	// MaxAttempts is the number of tries per Flush, defaults to DefaultOutboxMaxAttempts
+	MaxAttempts int
+
+	// Backoff is the wait before the first retry, doubled for every retry after
+	// it. Defaults to DefaultOutboxBackoff.
+	Backoff time.Duration
+
+	// MaxAge drops entries that still fail after this long, defaults to DefaultOutboxMaxAge
+	MaxAge time.Duration
+}
+
+func NewOutbox(o *OutboxOptions) *Outbox {
+	outbox := &Outbox{
+		cache:       o.Cache,
+		transport:   o.Transport,
+		maxAttempts: o.MaxAttempts,
+		backoff:     o.Backoff,
+		maxAge:      o.MaxAge,
+	}
+
+	if outbox.maxAttempts == 0 {
+		outbox.maxAttempts = DefaultOutboxMaxAttempts
+	}
+	if outbox.backoff == 0 {
+		outbox.backoff = DefaultOutboxBackoff
+	}
+	if outbox.maxAge == 0 {
+		outbox.maxAge = DefaultOutboxMaxAge
+	}
+
+	return outbox
+}
+
+func (o *Outbox) key() string {
+	return "outbox"
+}
+
+func (o *Outbox) sentKey(entryKey string) string {
+	return fmt.Sprintf("outbox:sent:%s", entryKey)
+}
+
+func (o *Outbox) load(ctx context.Context) ([]*OutboxEntry, error) {
+	if o.cache == nil {
+		return append([]*OutboxEntry{}, o.memory...), nil
+	}
+
+	entries := []*OutboxEntry{}
+	if !o.cache.Exists(ctx, o.key()) {
+		return entries, nil
+	}
+
+	if err := o.cache.Get(ctx, o.key(), &entries); err != nil {
+		return nil, fmt.Errorf("outbox: loading %s: %w", o.key(), err)
+	}
+	return entries, nil
+}
+
+func (o *Outbox) store(ctx context.Context, entries []*OutboxEntry) error {
+	if o.cache == nil {
+		o.memory = entries
+		return nil
+	}
+
+	if err := o.cache.Set(&common.Item{
+		Ctx:   ctx,
+		Key:   o.key(),
+		Value: entries,
+		TTL:   outboxTTL,
+	}); err != nil {
+		return fmt.Errorf("outbox: storing %s: %w", o.key(), err)
+	}
+	return nil
+}
+
+func (o *Outbox) sent(ctx context.Context, entryKey string) bool {
+	return o.cache != nil && o.cache.Exists(ctx, o.sentKey(entryKey))
+}
+
+func (o *Outbox) markSent(ctx context.Context, entry *OutboxEntry) error {
+	if o.cache == nil {
+		return nil
+	}
+
+	return o.cache.Set(&common.Item{
+		Ctx:   ctx,
+		Key:   o.sentKey(entry.Key),
+		Value: entry.Message.MessageID,
+		TTL:   outboxSentTTL,
+	})
+}
+
+// Enqueue adds an entry to the outbox. An entry with the same key as a queued
+// entry is ignored, one with the key of a sent entry returns ErrAlreadySent.
+// A queued entry for the same child and recipients is replaced: the items it
+// covers are still unseen, so the new entry reports them too.
+func (o *Outbox) Enqueue(ctx context.Context, entry *OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.sent(ctx, entry.Key) {
+		return ErrAlreadySent
+	}
+
+	entries, err := o.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	kept := []*OutboxEntry{}
+	for _, queued := range entries {
+		if queued.Key == entry.Key {
+			log.Printf("Outbox %s already holds %s\n", o.key(), entry.Key)
+			return nil
+		}
+
+		if !queued.sameAddressees(entry) {
+			kept = append(kept, queued)
+			continue
+		}
+
+		log.Printf("Outbox %s replaces %s with %s\n", o.key(), queued.Key, entry.Key)
+		// the items have been waiting since the first entry, it bounds MaxAge
+		if queued.EnqueuedAt.Before(entry.EnqueuedAt) {
+			entry.EnqueuedAt = queued.EnqueuedAt
+		}
+	}
+
+	return o.store(ctx, append(kept, entry))
+}
+
+// sameAddressees is true for entries about the same child, sent to the same people
+func (e *OutboxEntry) sameAddressees(other *OutboxEntry) bool {
+	return e.ChildID == other.ChildID &&
+		sortedJoin(e.Message.To) == sortedJoin(other.Message.To) &&
+		sortedJoin(e.Message.CC) == sortedJoin(other.Message.CC) &&
+		sortedJoin(e.Message.BCC) == sortedJoin(other.Message.BCC)
+}
+
+// MoveFrom moves the entries of the outbox kept in from under name, as
+// written before accounts had a cache prefix of their own, into this outbox.
+// The markers of entries sent from it are copied along.
+func (o *Outbox) MoveFrom(ctx context.Context, from *cache.Cache, name string) error {
+	if o.cache == nil {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	legacyKey := fmt.Sprintf("outbox:%s", name)
+	if !from.Exists(ctx, legacyKey) {
+		return nil
+	}
+
+	legacy := []*OutboxEntry{}
+	if err := from.Get(ctx, legacyKey, &legacy); err != nil {
+		return fmt.Errorf("outbox: loading %s: %w", legacyKey, err)
+	}
+
+	if from.Prefix() != o.cache.Prefix() {
+		if err := o.copySent(ctx, from); err != nil {
+			return err
+		}
+	}
+
+	entries, err := o.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range legacy {
+		if !slices.ContainsFunc(entries, func(queued *OutboxEntry) bool { return queued.Key == entry.Key }) {
+			entries = append(entries, entry)
+		}
+	}
+
+	log.Printf("Moving %d entries from outbox %s to %s%s\n", len(legacy), legacyKey, o.cache.Prefix(), o.key())
+	if err := o.store(ctx, entries); err != nil {
+		return err
+	}
+	return from.Delete(ctx, legacyKey)
+}
+
+// copySent copies the sent markers in from that this outbox does not have
+func (o *Outbox) copySent(ctx context.Context, from *cache.Cache) error {
+	keys, err := from.Scan(ctx, o.sentKey(""))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if o.cache.Exists(ctx, key) {
+			continue
+		}
+
+		var messageID string
+		ttl, err := from.TTL(ctx, key)
+		if err == nil {
+			err = from.Get(ctx, key, &messageID)
+		}
+		if errors.Is(err, common.ErrCacheMiss) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if ttl == 0 {
+			ttl = outboxSentTTL
+		}
+
+		if err := o.cache.Set(&common.Item{Ctx: ctx, Key: key, Value: messageID, TTL: ttl}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending returns the entries waiting to be sent
+func (o *Outbox) Pending(ctx context.Context) ([]*OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.load(ctx)
+}
+
+// Flush sends every queued entry, retrying failures with backoff. onSent is
+// called for every entry the transport accepted, after it has left the
+// outbox. Entries that still fail stay queued for the next Flush.
+//
+// Entries already marked sent are removed without sending them again, e.g.
+// when an earlier Flush could not remove them or read a stale outbox. Their
+// items are marked seen when the next run enqueues them, see ErrAlreadySent.
+func (o *Outbox) Flush(ctx context.Context, onSent func(*OutboxEntry)) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := o.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, entry := range entries {
+		if o.sent(ctx, entry.Key) {
+			log.Printf("Outbox %s already sent %s, removing it\n", o.key(), entry.Key)
+			if err := o.remove(ctx, entry.Key, nil); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+
+		if err := o.deliver(ctx, entry); err != nil {
+			errs = append(errs, fmt.Errorf("outbox: sending %s: %w", entry.Key, err))
+		} else if onSent != nil {
+			onSent(entry)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// deliver sends one entry and takes it out of the outbox when it went out, or
+// when it has been failing for longer than maxAge
+func (o *Outbox) deliver(ctx context.Context, entry *OutboxEntry) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		entry.Attempts++
+
+		var id string
+		id, err = o.sendOnce(ctx, entry.Message)
+		if err == nil {
+			log.Printf("Outbox %s sent %s as %s\n", o.key(), entry.Key, id)
+			break
+		}
+
+		entry.LastError = err.Error()
+		if attempt >= o.maxAttempts || ctx.Err() != nil {
+			break
+		}
+
+		delay := o.backoff << (attempt - 1)
+		delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+		log.Printf("Sending %s failed (attempt %d of %d), retrying in %s: %s\n", entry.Key, attempt, o.maxAttempts, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	if err == nil {
+		if markErr := o.markSent(ctx, entry); markErr != nil {
+			log.Printf("Could not remember %s as sent: %s\n", entry.Key, markErr)
+		}
+		return o.remove(ctx, entry.Key, nil)
+	}
+
+	if time.Since(entry.EnqueuedAt) > o.maxAge {
+		log.Printf("Dropping %s from outbox %s after %d attempts since %s: %s\n", entry.Key, o.key(), entry.Attempts, entry.EnqueuedAt.Format(time.RFC3339), err)
+		return errors.Join(err, o.remove(ctx, entry.Key, nil))
+	}
+
+	return errors.Join(err, o.remove(ctx, entry.Key, entry))
+}
+
+func (o *Outbox) sendOnce(ctx context.Context, message *Message) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return o.transport.Send(ctx, message)
+}
+
+// remove takes the entry with the key out of the stored outbox, or replaces
+// it when replacement is set. The outbox is read again, so entries enqueued
+// meanwhile are kept.
+func (o *Outbox) remove(ctx context.Context, entryKey string, replacement *OutboxEntry) error {
+	entries, err := o.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	kept := []*OutboxEntry{}
+	for _, entry := range entries {
+		if entry.Key != entryKey {
+			kept = append(kept, entry)
+		} else if replacement != nil {
+			kept = append(kept, replacement)
+		}
+	}
+
+	return o.store(ctx, kept)
+}
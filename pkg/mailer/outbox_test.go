@@ -0,0 +1,185 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/cache/common"
+	"github.com/eu-evops/edulink/pkg/edulink"
+)
+
+// flakyTransport fails the first failures sends and records the rest
+type flakyTransport struct {
+	failures int
+	sent     []*Message
+}
+
+func (t *flakyTransport) Send(ctx context.Context, message *Message) (string, error) {
+	if t.failures > 0 {
+		t.failures--
+		return "", errors.New("unavailable")
+	}
+	t.sent = append(t.sent, message)
+	return message.MessageID, nil
+}
+
+func testEntry(childID string, to []string, achievementIDs ...string) *OutboxEntry {
+	report := &edulink.SchoolReport{Child: edulink.Child{ID: childID}}
+	for _, id := range achievementIDs {
+		report.Achievement = append(report.Achievement, edulink.Achievement{ID: id})
+	}
+
+	return NewOutboxEntry(report, &Message{
+		From:    "reports@example.com",
+		To:      to,
+		Subject: childID,
+	})
+}
+
+func TestOutboxEnqueue(t *testing.T) {
+	parent := []string{"parent@example.com"}
+	other := []string{"other@example.com"}
+
+	tests := []struct {
+		name    string
+		entries []*OutboxEntry
+		// pending lists the achievement IDs of every entry left queued
+		pending [][]string
+	}{
+		{
+			name:    "same key",
+			entries: []*OutboxEntry{testEntry("c1", parent, "a1"), testEntry("c1", parent, "a1")},
+			pending: [][]string{{"a1"}},
+		},
+		{
+			name:    "superset for the same child and recipients",
+			entries: []*OutboxEntry{testEntry("c1", parent, "a1"), testEntry("c1", parent, "a1", "a2")},
+			pending: [][]string{{"a1", "a2"}},
+		},
+		{
+			name:    "other recipients",
+			entries: []*OutboxEntry{testEntry("c1", parent, "a1"), testEntry("c1", other, "a1", "a2")},
+			pending: [][]string{{"a1"}, {"a1", "a2"}},
+		},
+		{
+			name:    "other child",
+			entries: []*OutboxEntry{testEntry("c1", parent, "a1"), testEntry("c2", parent, "a2")},
+			pending: [][]string{{"a1"}, {"a2"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			outbox := NewOutbox(&OutboxOptions{Transport: &flakyTransport{}})
+
+			for _, entry := range test.entries {
+				if err := outbox.Enqueue(ctx, entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entries, err := outbox.Pending(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pending := [][]string{}
+			for _, entry := range entries {
+				pending = append(pending, entry.AchievementIDs)
+			}
+			if !reflect.DeepEqual(pending, test.pending) {
+				t.Errorf("pending %v, want %v", pending, test.pending)
+			}
+		})
+	}
+}
+
+// a failed entry and the next run's superset must not both be sent
+func TestOutboxFlushAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	parent := []string{"parent@example.com"}
+
+	transport := &flakyTransport{failures: 1}
+	outbox := NewOutbox(&OutboxOptions{Transport: transport, MaxAttempts: 1})
+
+	first := testEntry("c1", parent, "a1")
+	if err := outbox.Enqueue(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := outbox.Flush(ctx, nil); err == nil {
+		t.Fatal("first flush should fail")
+	}
+
+	second := testEntry("c1", parent, "a1", "a2")
+	if err := outbox.Enqueue(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+
+	flushed := []*OutboxEntry{}
+	if err := outbox.Flush(ctx, func(entry *OutboxEntry) { flushed = append(flushed, entry) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(transport.sent) != 1 || transport.sent[0] != second.Message {
+		t.Fatalf("sent %d messages, want only the second entry", len(transport.sent))
+	}
+	if len(flushed) != 1 || !reflect.DeepEqual(flushed[0].AchievementIDs, []string{"a1", "a2"}) {
+		t.Errorf("flushed %v, want one entry covering a1 and a2", flushed)
+	}
+	if !flushed[0].EnqueuedAt.Equal(first.EnqueuedAt) {
+		t.Errorf("enqueued at %s, want the first entry's %s", flushed[0].EnqueuedAt.Format(time.RFC3339Nano), first.EnqueuedAt.Format(time.RFC3339Nano))
+	}
+}
+
+// an entry an earlier Flush sent but could not take out of the outbox
+func TestOutboxFlushSkipsSentEntries(t *testing.T) {
+	ctx := context.Background()
+
+	c := cache.New(&common.CacheOptions{CacheType: common.Local})
+	if err := c.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &flakyTransport{}
+	outbox := NewOutbox(&OutboxOptions{Cache: c, Transport: transport})
+
+	sent := testEntry("c1", []string{"parent@example.com"}, "a1")
+	queued := testEntry("c2", []string{"parent@example.com"}, "a2")
+	for _, entry := range []*OutboxEntry{sent, queued} {
+		if err := outbox.Enqueue(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := outbox.markSent(ctx, sent); err != nil {
+		t.Fatal(err)
+	}
+
+	flushed := []*OutboxEntry{}
+	if err := outbox.Flush(ctx, func(entry *OutboxEntry) { flushed = append(flushed, entry) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(transport.sent) != 1 || transport.sent[0].MessageID != queued.Message.MessageID {
+		t.Errorf("sent %d messages, want only the queued entry", len(transport.sent))
+	}
+	if len(flushed) != 1 || flushed[0].Key != queued.Key {
+		t.Errorf("flushed %v, want only the queued entry", flushed)
+	}
+
+	pending, err := outbox.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("%d entries still pending", len(pending))
+	}
+
+	if err := outbox.Enqueue(ctx, testEntry("c1", []string{"parent@example.com"}, "a1")); !errors.Is(err, ErrAlreadySent) {
+		t.Errorf("enqueued the sent entry again: %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPSecurity string
+
+const (
+	// SMTPStartTLS upgrades a plain connection with STARTTLS, usually on port 587
+	SMTPStartTLS SMTPSecurity = "starttls"
+	// SMTPImplicitTLS connects over TLS from the start, usually on port 465
+	SMTPImplicitTLS SMTPSecurity = "tls"
+	// SMTPNone sends in the clear, only meant for local relays
+	SMTPNone SMTPSecurity = "none"
+)
+
+type SMTPAuth string
+
+const (
+	SMTPAuthPlain SMTPAuth = "plain"
+	SMTPAuthLogin SMTPAuth = "login"
+)
+
+// SMTPTransport sends messages through an SMTP relay
+type SMTPTransport struct {
+	options *SMTPTransportOptions
+}
+
+type SMTPTransportOptions struct {
+	Host string
+	Port int
+
+	// Security defaults to SMTPStartTLS
+	Security SMTPSecurity
+
+	// Auth defaults to SMTPAuthPlain, no authentication happens without a username
+	Auth     SMTPAuth
+	Username string
+	Password string
+
+	// TLSConfig overrides the TLS settings, e.g. to trust a private CA
+	TLSConfig *tls.Config
+
+	// Timeout bounds connecting, defaults to 10 seconds
+	Timeout time.Duration
+}
+
+func NewSMTPTransport(o *SMTPTransportOptions) (*SMTPTransport, error) {
+	if o.Host == "" {
+		return nil, errors.New("smtp: host is required")
+	}
+
+	if o.Security == "" {
+		o.Security = SMTPStartTLS
+	}
+
+	if o.Auth == "" {
+		o.Auth = SMTPAuthPlain
+	}
+
+	if o.Port == 0 {
+		switch o.Security {
+		case SMTPImplicitTLS:
+			o.Port = 465
+		case SMTPStartTLS:
+			o.Port = 587
+		default:
+			o.Port = 25
+		}
+	}
+
+	if o.Timeout == 0 {
+		o.Timeout = 10 * time.Second
+	}
+
+	switch o.Security {
+	case SMTPStartTLS, SMTPImplicitTLS, SMTPNone:
+	default:
+		return nil, fmt.Errorf("smtp: unknown security %q, expected starttls, tls or none", o.Security)
+	}
+
+	switch o.Auth {
+	case SMTPAuthPlain, SMTPAuthLogin:
+	default:
+		return nil, fmt.Errorf("smtp: unknown auth %q, expected plain or login", o.Auth)
+	}
+
+	return &SMTPTransport{options: o}, nil
+}
+
+func (t *SMTPTransport) tlsConfig() *tls.Config {
+	if t.options.TLSConfig != nil {
+		config := t.options.TLSConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName = t.options.Host
+		}
+		return config
+	}
+
+	return &tls.Config{ServerName: t.options.Host}
+}
+
+func (t *SMTPTransport) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(t.options.Host, fmt.Sprint(t.options.Port))
+	dialer := &net.Dialer{Timeout: t.options.Timeout}
+
+	var conn net.Conn
+	var err error
+	if t.options.Security == SMTPImplicitTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: t.tlsConfig()}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, t.options.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (t *SMTPTransport) Send(ctx context.Context, m *Message) (string, error) {
+	if m.MessageID == "" {
+		m.MessageID = newMessageID(domainOf(m.From))
+	}
+
+	data, err := m.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	client, err := t.dial(ctx)
+	if err != nil {
+		return "", fmt.Errorf("smtp: connecting: %w", err)
+	}
+	defer client.Close()
+
+	if t.options.Security == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return "", errors.New("smtp: server does not support STARTTLS")
+		}
+		if err := client.StartTLS(t.tlsConfig()); err != nil {
+			return "", fmt.Errorf("smtp: starttls: %w", err)
+		}
+	}
+
+	if t.options.Username != "" {
+		if err := client.Auth(t.auth()); err != nil {
+			return "", fmt.Errorf("smtp: auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(addressOf(m.From)); err != nil {
+		return "", fmt.Errorf("smtp: mail from: %w", err)
+	}
+
+	for _, recipient := range m.Recipients() {
+		if err := client.Rcpt(addressOf(recipient)); err != nil {
+			return "", fmt.Errorf("smtp: rcpt to %s: %w", recipient, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return "", fmt.Errorf("smtp: data: %w", err)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return "", fmt.Errorf("smtp: data: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("smtp: data: %w", err)
+	}
+
+	if err := client.Quit(); err != nil {
+		return "", fmt.Errorf("smtp: quit: %w", err)
+	}
+
+	return m.MessageID, nil
+}
+
+func (t *SMTPTransport) auth() smtp.Auth {
+	if t.options.Auth == SMTPAuthLogin {
+		return &loginAuth{username: t.options.Username, password: t.options.Password}
+	}
+
+	return &plainAuth{username: t.options.Username, password: t.options.Password}
+}
+
+// plainAuth implements AUTH PLAIN. Unlike smtp.PlainAuth it leaves the
+// decision to send credentials in the clear to the Security setting.
+type plainAuth struct {
+	username string
+	password string
+}
+
+func (a *plainAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a *plainAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("unexpected server challenge")
+	}
+	return nil, nil
+}
+
+// loginAuth implements AUTH LOGIN, which net/smtp does not provide
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
+
+// addressOf returns the bare address of "Name <address>"
+func addressOf(address string) string {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return strings.TrimSpace(address)
+	}
+	return parsed.Address
+}
+
+func domainOf(address string) string {
+	address = addressOf(address)
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
@@ -0,0 +1,147 @@
+package mailer_test
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/eu-evops/edulink/pkg/mailer"
+	"github.com/eu-evops/edulink/pkg/mailer/mailertest"
+)
+
+func newSMTPServer(t *testing.T, options *mailertest.SMTPServerOptions) *mailertest.SMTPServer {
+	t.Helper()
+
+	server, err := mailertest.NewSMTPServer(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func testMessage() *mailer.Message {
+	return &mailer.Message{
+		From:    "Reports <reports@example.com>",
+		To:      []string{"Parent <parent@example.com>"},
+		CC:      []string{"tutor@example.com"},
+		BCC:     []string{"head@example.com"},
+		Subject: "School report",
+		HTML:    "<p>Well done</p>",
+	}
+}
+
+func TestSMTPTransportSends(t *testing.T) {
+	tests := []struct {
+		security mailer.SMTPSecurity
+		auth     mailer.SMTPAuth
+		wantTLS  bool
+	}{
+		{mailer.SMTPStartTLS, mailer.SMTPAuthPlain, true},
+		{mailer.SMTPStartTLS, mailer.SMTPAuthLogin, true},
+		{mailer.SMTPImplicitTLS, mailer.SMTPAuthPlain, true},
+		{mailer.SMTPImplicitTLS, mailer.SMTPAuthLogin, true},
+		{mailer.SMTPNone, mailer.SMTPAuthPlain, false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.security)+" "+string(test.auth), func(t *testing.T) {
+			server := newSMTPServer(t, &mailertest.SMTPServerOptions{
+				Security: test.security,
+				Username: "relay",
+				Password: "secret",
+			})
+
+			options := server.TransportOptions()
+			options.Auth = test.auth
+			transport, err := mailer.NewSMTPTransport(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			message := testMessage()
+			id, err := transport.Send(context.Background(), message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id == "" || id != message.MessageID {
+				t.Errorf("sent as %q, want the message ID %q", id, message.MessageID)
+			}
+
+			received := server.Messages()
+			if len(received) != 1 {
+				t.Fatalf("received %d messages, want 1", len(received))
+			}
+			got := received[0]
+
+			if got.From != "reports@example.com" {
+				t.Errorf("from %q, want reports@example.com", got.From)
+			}
+			if want := []string{"parent@example.com", "tutor@example.com", "head@example.com"}; !slices.Equal(got.To, want) {
+				t.Errorf("to %v, want %v", got.To, want)
+			}
+			if got.Username != "relay" {
+				t.Errorf("authenticated as %q, want relay", got.Username)
+			}
+			if got.TLS != test.wantTLS {
+				t.Errorf("TLS %t, want %t", got.TLS, test.wantTLS)
+			}
+			if !bytes.Contains(got.Data, []byte("Subject: School report")) {
+				t.Errorf("data has no subject:\n%s", got.Data)
+			}
+			if bytes.Contains(got.Data, []byte("head@example.com")) {
+				t.Errorf("data shows the BCC recipient:\n%s", got.Data)
+			}
+		})
+	}
+}
+
+func TestSMTPTransportFails(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  *mailertest.SMTPServerOptions
+		options func(options *mailer.SMTPTransportOptions)
+	}{
+		{
+			name:    "wrong password",
+			server:  &mailertest.SMTPServerOptions{Username: "relay", Password: "secret"},
+			options: func(options *mailer.SMTPTransportOptions) { options.Password = "wrong" },
+		},
+		{
+			name:    "no credentials",
+			server:  &mailertest.SMTPServerOptions{Username: "relay", Password: "secret"},
+			options: func(options *mailer.SMTPTransportOptions) { options.Username = "" },
+		},
+		{
+			name:    "STARTTLS not offered",
+			server:  &mailertest.SMTPServerOptions{Security: mailer.SMTPNone},
+			options: func(options *mailer.SMTPTransportOptions) { options.Security = mailer.SMTPStartTLS },
+		},
+		{
+			name:    "untrusted certificate",
+			server:  &mailertest.SMTPServerOptions{Security: mailer.SMTPImplicitTLS},
+			options: func(options *mailer.SMTPTransportOptions) { options.TLSConfig = nil },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newSMTPServer(t, test.server)
+
+			options := server.TransportOptions()
+			test.options(options)
+			transport, err := mailer.NewSMTPTransport(options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := transport.Send(context.Background(), testMessage()); err == nil {
+				t.Error("sent without an error")
+			}
+			if received := server.Messages(); len(received) != 0 {
+				t.Errorf("received %d messages, want none", len(received))
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+)
+
+// DefaultSubject is the subject used when MailerOptions.Subject is not set
+const DefaultSubject = "EduLink School Report: {{ .Child.Forename }}"
+
+var subjectFuncs = template.FuncMap{
+	"pluralize": func(val int, text string) string {
+		if val == 1 {
+			return fmt.Sprintf("%d %s", val, text)
+		}
+		return fmt.Sprintf("%d %ss", val, text)
+	},
+	// signed renders a number with its sign, e.g. +7 or -2
+	"signed": func(val int) string {
+		return fmt.Sprintf("%+d", val)
+	},
+}
+
+// ParseSubject parses a subject template, executed against the
+// edulink.SchoolReport being sent, e.g.
+//
+//	{{ .Child.Forename }}: {{ pluralize (len .Achievement) "achievement" }}, {{ pluralize (len .Behaviour) "behaviour" }} (net {{ signed .NetPoints }} points)
+func ParseSubject(text string) (*template.Template, error) {
+	return template.New("subject").Funcs(subjectFuncs).Parse(text)
+}
+
+func renderSubject(subject *template.Template, schoolReport *edulink.SchoolReport) (string, error) {
+	var buf strings.Builder
+	if err := subject.Execute(&buf, schoolReport); err != nil {
+		return "", err
+	}
+
+	// a header can only hold a single line
+	return strings.Join(strings.Fields(buf.String()), " "), nil
+}
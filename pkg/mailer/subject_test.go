@@ -0,0 +1,72 @@
+package mailer
+
+import (
+	"testing"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+)
+
+func testReport() *edulink.SchoolReport {
+	return &edulink.SchoolReport{
+		Child:       edulink.Child{ID: "1001", Forename: "Sam"},
+		Achievement: []edulink.Achievement{{ID: "a1", Points: 2}, {ID: "a2", Points: 3}},
+		Behaviour:   []edulink.Behaviour{{ID: "b1", Points: -1}},
+	}
+}
+
+func TestSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		want    string
+		// wantParseErr and wantRenderErr are where a broken subject fails
+		wantParseErr  bool
+		wantRenderErr bool
+	}{
+		{name: "default", subject: DefaultSubject, want: "EduLink School Report: Sam"},
+		{
+			name:    "functions",
+			subject: `{{ .Child.Forename }}: {{ pluralize (len .Achievement) "achievement" }}, {{ pluralize (len .Behaviour) "behaviour" }} (net {{ signed .NetPoints }} points)`,
+			want:    "Sam: 2 achievements, 1 behaviour (net +4 points)",
+		},
+		{name: "one line", subject: "Report\n  for {{ .Child.Forename }}\n", want: "Report for Sam"},
+		{name: "bad syntax", subject: "Report for {{ .Child.Forename", wantParseErr: true},
+		{name: "unknown function", subject: "{{ shout .Child.Forename }}", wantParseErr: true},
+		{name: "missing field", subject: "Report for {{ .Child.Nickname }}", wantRenderErr: true},
+		{name: "wrong argument", subject: `{{ pluralize .Child.Forename "report" }}`, wantRenderErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subject, err := ParseSubject(test.subject)
+			if (err != nil) != test.wantParseErr {
+				t.Fatalf("ParseSubject = %v, want an error %t", err, test.wantParseErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got, err := renderSubject(subject, testReport())
+			if (err != nil) != test.wantRenderErr {
+				t.Fatalf("renderSubject = %q, %v, want an error %t", got, err, test.wantRenderErr)
+			}
+			if got != test.want {
+				t.Errorf("renderSubject = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// a subject that fails for a report falls back to the default
+func TestComposeFallsBackToDefaultSubject(t *testing.T) {
+	subject, err := ParseSubject("Report for {{ .Child.Nickname }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMailer(&MailerOptions{Subject: subject})
+	message := m.Compose(testReport(), "<p>report</p>", "report", Recipients{To: []string{"parent@example.com"}})
+	if message.Subject != "EduLink School Report: Sam" {
+		t.Errorf("subject %q, want the default", message.Subject)
+	}
+}
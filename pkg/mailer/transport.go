@@ -0,0 +1,8 @@
+package mailer
+
+import "context"
+
+// Transport delivers a rendered message and returns the ID it was sent with
+type Transport interface {
+	Send(ctx context.Context, message *Message) (string, error)
+}
@@ -0,0 +1,223 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	expression string
+
+	minute [60]bool
+	hour   [24]bool
+	dom    [32]bool
+	month  [13]bool
+	dow    [7]bool
+
+	// standard cron matches either day field when both are restricted
+	domRestricted bool
+	dowRestricted bool
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Parse parses a five field cron expression: minute, hour, day of month,
+// month and day of week. Fields take *, numbers, ranges (1-5), steps (*/15,
+// 8-18/2), lists (1,15) and, for months and days, names (jan, mon-fri).
+// The macros @hourly, @daily, @weekly, @monthly and @yearly are accepted too.
+//
+//	30 16 * * mon-fri  weekdays at 16:30
+//	0 17 * * fri       Fridays at 17:00
+func Parse(expression string) (*Schedule, error) {
+	fields := strings.Fields(strings.ToLower(expression))
+	if len(fields) == 1 {
+		if macro, ok := macros[fields[0]]; ok {
+			fields = strings.Fields(macro)
+		}
+	}
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: %q needs 5 fields, minute hour day-of-month month day-of-week", expression)
+	}
+
+	s := &Schedule{expression: expression}
+
+	if err := parseField(fields[0], 0, 59, nil, s.minute[:]); err != nil {
+		return nil, fmt.Errorf("cron: %q minute: %w", expression, err)
+	}
+	if err := parseField(fields[1], 0, 23, nil, s.hour[:]); err != nil {
+		return nil, fmt.Errorf("cron: %q hour: %w", expression, err)
+	}
+	if err := parseField(fields[2], 1, 31, nil, s.dom[:]); err != nil {
+		return nil, fmt.Errorf("cron: %q day of month: %w", expression, err)
+	}
+	if err := parseField(fields[3], 1, 12, monthNames, s.month[:]); err != nil {
+		return nil, fmt.Errorf("cron: %q month: %w", expression, err)
+	}
+
+	// 7 is Sunday as well
+	var dow [8]bool
+	if err := parseField(fields[4], 0, 7, dayNames, dow[:]); err != nil {
+		return nil, fmt.Errorf("cron: %q day of week: %w", expression, err)
+	}
+	copy(s.dow[:], dow[:7])
+	s.dow[0] = s.dow[0] || dow[7]
+
+	s.domRestricted = fields[2] != "*"
+	s.dowRestricted = fields[4] != "*"
+
+	return s, nil
+}
+
+// MustParse is like Parse but panics on an invalid expression
+func MustParse(expression string) *Schedule {
+	s, err := Parse(expression)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *Schedule) String() string {
+	return s.expression
+}
+
+func parseField(field string, min int, max int, names map[string]int, set []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		from, to := min, max
+		if rangePart != "*" {
+			fromPart, toPart, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			if from, err = parseValue(fromPart, names); err != nil {
+				return err
+			}
+
+			to = from
+			if isRange {
+				if to, err = parseValue(toPart, names); err != nil {
+					return err
+				}
+			} else if hasStep {
+				// 5/15 runs from 5 to the end of the range
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+
+	return nil
+}
+
+func parseValue(value string, names map[string]int) (int, error) {
+	if v, ok := names[value]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	return v, nil
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom[t.Day()]
+	dow := s.dow[int(t.Weekday())]
+
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Next returns the first time after t the schedule fires, in t's location.
+// It returns the zero time when the schedule never fires, e.g. on 30 February.
+// Times skipped when the clocks go forward do not fire, those repeated when
+// they go back fire the first time only.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+
+	// whole minutes, steps below move forward in absolute time so daylight
+	// saving changes neither loop nor skip
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !s.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+
+		if !s.hour[t.Hour()] {
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+
+		if !s.minute[t.Minute()] || repeated(t) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// repeated reports whether the wall clock showed t's time before, because the
+// clocks went back in between
+func repeated(t time.Time) bool {
+	_, offset := t.Zone()
+	_, earlierOffset := t.Add(-3 * time.Hour).Zone()
+
+	shift := time.Duration(earlierOffset-offset) * time.Second
+	if shift <= 0 {
+		return false
+	}
+
+	earlier := t.Add(-shift)
+	return earlier.Hour() == t.Hour() && earlier.Minute() == t.Minute()
+}
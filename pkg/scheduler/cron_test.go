@@ -0,0 +1,170 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expression string
+		wantErr    bool
+	}{
+		{expression: "* * * * *"},
+		{expression: "30 16 * * mon-fri"},
+		{expression: "0 17 * * FRI"},
+		{expression: "*/15 8-18/2 1,15 jan-jun,dec 0-7"},
+		{expression: "5/15 * * * *"},
+		{expression: "@daily"},
+		{expression: "@Weekly"},
+		{expression: "", wantErr: true},
+		{expression: "* * * *", wantErr: true},
+		{expression: "* * * * * *", wantErr: true},
+		{expression: "@fortnightly", wantErr: true},
+		{expression: "60 * * * *", wantErr: true},
+		{expression: "* 24 * * *", wantErr: true},
+		{expression: "* * 0 * *", wantErr: true},
+		{expression: "* * * 13 *", wantErr: true},
+		{expression: "* * * * 8", wantErr: true},
+		{expression: "10-5 * * * *", wantErr: true},
+		{expression: "*/0 * * * *", wantErr: true},
+		{expression: "* * * * someday", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := Parse(test.expression)
+			if test.wantErr && err == nil {
+				t.Error("parsed, want an error")
+			}
+			if !test.wantErr && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	at := func(value string) time.Time {
+		t.Helper()
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, london)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		after      time.Time
+		want       []time.Time
+	}{
+		{
+			name:       "every minute rounds up",
+			expression: "* * * * *",
+			after:      at("2026-10-14 09:00").Add(30 * time.Second),
+			want:       []time.Time{at("2026-10-14 09:01"), at("2026-10-14 09:02")},
+		},
+		{
+			name:       "weekdays skip the weekend",
+			expression: "30 16 * * mon-fri",
+			after:      at("2026-10-16 16:30"),
+			want:       []time.Time{at("2026-10-19 16:30"), at("2026-10-20 16:30")},
+		},
+		{
+			name:       "steps within a range",
+			expression: "0 8-12/2 * * *",
+			after:      at("2026-10-14 09:00"),
+			want:       []time.Time{at("2026-10-14 10:00"), at("2026-10-14 12:00"), at("2026-10-15 08:00")},
+		},
+		{
+			name:       "either day field when both are restricted",
+			expression: "0 9 1 * fri",
+			after:      at("2026-10-29 12:00"),
+			want:       []time.Time{at("2026-10-30 09:00"), at("2026-11-01 09:00"), at("2026-11-06 09:00")},
+		},
+		{
+			name:       "sunday as 7",
+			expression: "0 9 * * 7",
+			after:      at("2026-10-14 12:00"),
+			want:       []time.Time{at("2026-10-18 09:00")},
+		},
+		{
+			name:       "yearly",
+			expression: "@yearly",
+			after:      at("2026-06-01 00:00"),
+			want:       []time.Time{at("2027-01-01 00:00")},
+		},
+		{
+			name:       "29 February",
+			expression: "0 12 29 2 *",
+			after:      at("2026-03-01 00:00"),
+			want:       []time.Time{at("2028-02-29 12:00")},
+		},
+		{
+			name:       "never",
+			expression: "0 0 30 2 *",
+			after:      at("2026-01-01 00:00"),
+			want:       []time.Time{{}},
+		},
+		{
+			// clocks go forward at 01:00 on 29 March 2026, 01:30 does not exist
+			name:       "spring forward skips the missing time",
+			expression: "30 1 * * *",
+			after:      at("2026-03-28 12:00"),
+			want:       []time.Time{at("2026-03-30 01:30")},
+		},
+		{
+			name:       "spring forward keeps the hour after",
+			expression: "0 * * * *",
+			after:      at("2026-03-29 00:30"),
+			want:       []time.Time{at("2026-03-29 02:00"), at("2026-03-29 03:00")},
+		},
+		{
+			// clocks go back at 02:00 on 25 October 2026, 01:30 happens twice
+			name:       "fall back fires once",
+			expression: "30 1 * * *",
+			after:      at("2026-10-24 12:00"),
+			want:       []time.Time{time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC), at("2026-10-26 01:30")},
+		},
+		{
+			name:       "fall back hourly",
+			expression: "0 * * * *",
+			after:      at("2026-10-25 00:30"),
+			want:       []time.Time{time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC), at("2026-10-25 02:00")},
+		},
+		{
+			name:       "fall back daily run",
+			expression: "30 16 * * *",
+			after:      at("2026-10-24 16:30"),
+			want:       []time.Time{at("2026-10-25 16:30"), at("2026-10-26 16:30")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := Parse(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			after := test.after
+			for _, want := range test.want {
+				got := schedule.Next(after)
+				if !got.Equal(want) {
+					t.Fatalf("Next(%s) = %s, want %s", after, got, want)
+				}
+				if !got.IsZero() && got.Location() != london {
+					t.Errorf("Next(%s) is in %s, want %s", after, got.Location(), london)
+				}
+				after = got
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// Job is run every time its schedule fires
+type Job struct {
+	Name     string
+	Schedule *Schedule
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs jobs on their cron schedules. Jobs run one at a time, a
+// schedule that fires while another run is in flight is skipped rather than
+// queued.
+type Scheduler struct {
+	location     *time.Location
+	jobs         []Job
+	drainTimeout time.Duration
+	logger       *log.Logger
+}
+
+type SchedulerOptions struct {
+	// Location the schedules are evaluated in, defaults to time.Local
+	Location *time.Location
+
+	Jobs []Job
+
+	// DrainTimeout bounds how long the in-flight run may continue after the
+	// scheduler is stopped, before its context is cancelled. Zero waits for
+	// the run to finish however long it takes.
+	DrainTimeout time.Duration
+
+	// Logger defaults to the standard logger
+	Logger *log.Logger
+}
+
+func New(o *SchedulerOptions) *Scheduler {
+	s := &Scheduler{
+		location:     o.Location,
+		jobs:         o.Jobs,
+		drainTimeout: o.DrainTimeout,
+		logger:       o.Logger,
+	}
+
+	if s.location == nil {
+		s.location = time.Local
+	}
+
+	if s.logger == nil {
+		s.logger = log.Default()
+	}
+
+	return s
+}
+
+// next returns the job that fires first after t, and when
+func (s *Scheduler) next(t time.Time) (*Job, time.Time) {
+	var job *Job
+	var at time.Time
+
+	for i := range s.jobs {
+		next := s.jobs[i].Schedule.Next(t.In(s.location))
+		if next.IsZero() {
+			continue
+		}
+
+		if job == nil || next.Before(at) {
+			job = &s.jobs[i]
+			at = next
+		}
+	}
+
+	return job, at
+}
+
+// Run runs the jobs until ctx is cancelled. A run in flight when that happens
+// is drained: Run waits for it to finish before returning.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		job, at := s.next(time.Now())
+		if job == nil {
+			return errors.New("scheduler: no schedule fires again")
+		}
+
+		s.logger.Printf("Next run: %s at %s\n", job.Name, at.Format(time.RFC1123))
+
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.logger.Println("Scheduler stopped")
+			return nil
+		case <-timer.C:
+		}
+
+		s.run(ctx, job)
+
+		// fires missed while the job ran are skipped, not caught up
+		if _, next := s.next(at); !next.IsZero() && time.Now().After(next) {
+			s.logger.Printf("Run of %s overran, skipping the runs it overlapped\n", job.Name)
+		}
+
+		if ctx.Err() != nil {
+			s.logger.Println("Scheduler stopped after draining the last run")
+			return nil
+		}
+	}
+}
+
+// RunOnce runs job straight away and returns its error. Like a scheduled run,
+// it is drained rather than cut short when ctx is cancelled.
+func (s *Scheduler) RunOnce(ctx context.Context, job *Job) error {
+	return s.run(ctx, job)
+}
+
+// run runs a job to completion. Its context outlives ctx by drainTimeout, so
+// stopping the scheduler lets the run finish instead of cutting it short.
+func (s *Scheduler) run(ctx context.Context, job *Job) error {
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+
+		s.logger.Printf("Stopping, waiting for the run of %s to finish\n", job.Name)
+		if s.drainTimeout == 0 {
+			return
+		}
+
+		timer := time.NewTimer(s.drainTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-done:
+		case <-timer.C:
+			s.logger.Printf("Run of %s did not finish within %s, cancelling it\n", job.Name, s.drainTimeout)
+			cancel()
+		}
+	}()
+
+	start := time.Now()
+	s.logger.Printf("Running %s\n", job.Name)
+
+	err := runJob(runCtx, job)
+	if err != nil {
+		s.logger.Printf("Run of %s failed after %s: %s\n", job.Name, time.Since(start).Round(time.Millisecond), err)
+		return err
+	}
+
+	s.logger.Printf("Run of %s finished after %s\n", job.Name, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// runJob turns a panicking job into an error, so one bad run does not stop
+// the scheduler
+func runJob(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New("panic in scheduled job")
+			log.Printf("Recovered from panic in %s: %v\n", job.Name, r)
+		}
+	}()
+
+	return job.Run(ctx)
+}
@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestRunOnceDrains(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+		runFor       time.Duration
+		wantErr      error
+	}{
+		{name: "finishes within the drain timeout", drainTimeout: time.Second, runFor: 50 * time.Millisecond},
+		{name: "no drain timeout waits", runFor: 50 * time.Millisecond},
+		{name: "cancelled after the drain timeout", drainTimeout: 20 * time.Millisecond, runFor: time.Second, wantErr: context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := New(&SchedulerOptions{
+				DrainTimeout: test.drainTimeout,
+				Logger:       log.New(io.Discard, "", 0),
+			})
+
+			// stopped as soon as the run starts, like docker stop mid-run
+			ctx, stop := context.WithCancel(context.Background())
+			err := s.RunOnce(ctx, &Job{
+				Name: "test",
+				Run: func(runCtx context.Context) error {
+					stop()
+
+					select {
+					case <-time.After(test.runFor):
+						return nil
+					case <-runCtx.Done():
+						return runCtx.Err()
+					}
+				},
+			})
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunOnceRecoversPanics(t *testing.T) {
+	s := New(&SchedulerOptions{Logger: log.New(io.Discard, "", 0)})
+
+	err := s.RunOnce(context.Background(), &Job{
+		Name: "panics",
+		Run: func(ctx context.Context) error {
+			panic("boom")
+		},
+	})
+	if err == nil {
+		t.Error("a panicking job should fail")
+	}
+}
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -9,21 +10,43 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/mailer"
+	"github.com/eu-evops/edulink/pkg/worker"
 )
 
 type Server struct {
-	mux  *http.ServeMux
-	port int
+	mux        *http.ServeMux
+	port       int
+	accounts   []*worker.Account
+	deliveries *mailer.DeliveryLog
+	signingKey string
+	cancel     context.CancelFunc
 }
 
-func NewServer(webserverPort int) *Server {
+type ServerOptions struct {
+	Port int
+
+	// Accounts are reported on at /, and per school at /schools/{school code}/
+	Accounts []*worker.Account
+
+	// Deliveries shows the delivery status of sent reports, when set
+	Deliveries *mailer.DeliveryLog
+
+	// MailgunWebhookSigningKey enables the Mailgun event webhook at
+	// /webhooks/mailgun, events are recorded in Deliveries
+	MailgunWebhookSigningKey string
+}
+
+func NewServer(o *ServerOptions) *Server {
 	return &Server{
-		port: webserverPort,
+		port:       o.Port,
+		accounts:   o.Accounts,
+		deliveries: o.Deliveries,
+		signingKey: o.MailgunWebhookSigningKey,
 	}
 }
 
@@ -52,39 +75,42 @@ func (s *Server) Start() error {
 
 	s.mux = http.NewServeMux()
 
-	edulinkReporter := edulink.NewReporter(&edulink.ReporterOptions{
-		Cache:    edulink.Cache,
-		Username: os.Getenv("EDULINK_USERNAME"),
-		Password: os.Getenv("EDULINK_PASSWORD"),
-	})
-
-	s.mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Content-Type", "text/html; charset=utf-8")
-		w.Header().Add("X-EduLink-Version", fmt.Sprintf("%T", edulinkReporter))
+	s.mux.Handle("/", s.makeReportsHandler(s.accounts, templ))
 
-		reports := edulinkReporter.Prepare(&edulink.PrepareOptions{
-			MaximumAge:     edulink.Month,
-			ReportPrevious: true,
-		})
+	if s.deliveries != nil && s.signingKey != "" {
+		s.mux.Handle("/webhooks/mailgun", NewMailgunWebhook(s.signingKey, s.deliveries))
+	}
 
-		w.Header().Add("X-EduLink-NumberOfReports", fmt.Sprintf("%d", len(*reports)))
-		for _, report := range *reports {
-			reportText := edulinkReporter.Generate(&report)
-			fmt.Fprintf(w, "%s", reportText)
+	// the schools of accounts that are not connected yet get no pages of their own
+	schools := map[string]bool{}
+	for _, account := range s.accounts {
+		session := account.Connected()
+		if session == nil {
+			continue
 		}
 
-		if len(*reports) == 0 {
-			fmt.Fprintf(w, "<h1>No reports available</h1>")
+		school := session.Client().School()
+		if schools[school.Code] {
+			continue
 		}
+		schools[school.Code] = true
 
-	}))
+		schoolAccounts := []*worker.Account{}
+		for _, other := range s.accounts {
+			if otherSession := other.Connected(); otherSession != nil && otherSession.Client().School().Code == school.Code {
+				schoolAccounts = append(schoolAccounts, other)
+			}
+		}
 
-	s.mux.Handle(makeHandler("EduLink.SchoolDetails", makeEdulinkSchoolDetailsRequest, makeEdulinkSchoolDetailsResult, templ))
-	s.mux.Handle(makeHandler("EduLink.AchievementBehaviourLookups", makeEdulinkAchievementBehaviourLookupsRequest, makeEdulinkAchievementBehaviourLookupsResult, templ))
+		s.mux.Handle(schoolPath(school.Code), s.makeReportsHandler(schoolAccounts, templ))
+		s.mux.Handle(s.makeHandler(session, "EduLink.SchoolDetails", makeEdulinkSchoolDetailsRequest, makeEdulinkSchoolDetailsResult, templ))
+		s.mux.Handle(s.makeHandler(session, "EduLink.AchievementBehaviourLookups", makeEdulinkAchievementBehaviourLookupsRequest, makeEdulinkAchievementBehaviourLookupsResult, templ))
+	}
 
 	s.mux.Handle("/public/", http.FileServer(http.Dir(".")))
 
-	serverContext, _ := context.WithTimeout(context.Background(), 10*time.Second)
+	// requests are only cancelled by Stop, the timeouts below bound each one
+	serverContext, cancel := context.WithCancel(context.Background())
 	server := &http.Server{
 		Addr:              fmt.Sprintf(":%d", s.port),
 		Handler:           s.mux,
@@ -93,11 +119,77 @@ func (s *Server) Start() error {
 		BaseContext:       func(listener net.Listener) context.Context { return serverContext },
 	}
 
+	s.cancel = cancel
+
 	go server.ListenAndServe()
 
 	return nil
 }
 
+// makeReportsHandler renders the reports of every child on the given accounts,
+// followed by the delivery status of the reports sent for the child. Accounts
+// are skipped until the worker connected them.
+func (s *Server) makeReportsHandler(accounts []*worker.Account, templ *template.Template) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "text/html; charset=utf-8")
+		w.Header().Add("X-EduLink-Version", fmt.Sprintf("%T", &edulink.Reporter{}))
+
+		var body bytes.Buffer
+		numberOfReports := 0
+
+		// the delivery status is styled by the site, reports bring their own style
+		if s.deliveries != nil {
+			fmt.Fprintf(&body, `<link href="/public/styles/main.css" type="text/css" rel="stylesheet">`)
+		}
+		for _, account := range accounts {
+			session := account.Connected()
+			if session == nil {
+				fmt.Fprintf(&body, "<h1>Not connected to EduLink yet</h1>")
+				continue
+			}
+
+			school := session.Client().School().Code
+			edulinkReporter := edulink.NewReporter(&edulink.ReporterOptions{
+				Session: session,
+				Cache:   account.Cache(session.Client().Cache()),
+			})
+
+			reports, err := edulinkReporter.Prepare(r.Context(), &edulink.PrepareOptions{
+				MaximumAge:     edulink.Month,
+				ReportPrevious: true,
+			})
+			if err != nil {
+				log.Printf("Error preparing reports: %s\n", err)
+				fmt.Fprintf(&body, "<h1>Error: %s</h1>", template.HTMLEscapeString(err.Error()))
+				continue
+			}
+
+			numberOfReports += len(*reports)
+			for _, report := range *reports {
+				reportText := edulinkReporter.Generate(&report)
+				fmt.Fprintf(&body, "%s", reportText)
+
+				if s.deliveries != nil {
+					statuses, err := s.deliveries.ForChild(r.Context(), school, report.Child.ID)
+					if err != nil {
+						log.Printf("Error loading deliveries for %s: %s\n", report.Child.Forename, err)
+					}
+					if err := templ.ExecuteTemplate(&body, "deliveries", statuses); err != nil {
+						log.Printf("Error: %s", err)
+					}
+				}
+			}
+		}
+
+		w.Header().Add("X-EduLink-NumberOfReports", fmt.Sprintf("%d", numberOfReports))
+		body.WriteTo(w)
+
+		if numberOfReports == 0 {
+			fmt.Fprintf(w, "<h1>No reports available</h1>")
+		}
+	})
+}
+
 type LoggingHandler struct {
 	handler http.HandlerFunc
 }
@@ -127,19 +219,19 @@ func (h *LoggingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Finished request for %s, duration: %dms, size: %dkb", r.URL.Path, duration.Milliseconds(), wrapped.contentLength/1/1024)
 }
 
-func makeEdulinkSchoolDetailsRequest(r *http.Request) edulink.Request {
+func makeEdulinkSchoolDetailsRequest(c *edulink.Client, r *http.Request) edulink.AuthenticatedRequest {
 	return &edulink.SchoolDetailsRequest{
 		RequestBase: edulink.RequestBase{
 			JsonRPC: "2.0",
 			Method:  "EduLink.SchoolDetails",
 		},
 		Params: edulink.SchoolDetailsRequestParams{
-			EstablishmentID: 2,
+			EstablishmentID: c.EstablishmentID(),
 		},
 	}
 }
 
-func makeEdulinkAchievementBehaviourLookupsRequest(r *http.Request) edulink.Request {
+func makeEdulinkAchievementBehaviourLookupsRequest(c *edulink.Client, r *http.Request) edulink.AuthenticatedRequest {
 	return &edulink.AchievementBehaviourLookupsRequest{
 		RequestBase: edulink.RequestBase{
 			JsonRPC: "2.0",
@@ -156,14 +248,14 @@ func makeEdulinkAchievementBehaviourLookupsResult() edulink.Result {
 	return &edulink.AchievementBehaviourLookupsResponse{}
 }
 
-type makeEdulinkRequest func(*http.Request) edulink.Request
+type makeEdulinkRequest func(*edulink.Client, *http.Request) edulink.AuthenticatedRequest
 type makeEdulinkResult func() edulink.Result
 
-func makeHandler(method string, makeRequest makeEdulinkRequest, makeResult makeEdulinkResult, templ *template.Template) (string, http.Handler) {
+func (s *Server) makeHandler(session *edulink.Session, method string, makeRequest makeEdulinkRequest, makeResult makeEdulinkResult, templ *template.Template) (string, http.Handler) {
 	h := func(w http.ResponseWriter, r *http.Request) {
-		req := makeRequest(r)
+		req := makeRequest(session.Client(), r)
 		res := makeResult()
-		if err := edulink.Call(r.Context(), req, res); err != nil {
+		if err := session.Call(r.Context(), req, res); err != nil {
 			fmt.Fprintf(w, "Error: %s", err)
 			return
 		}
@@ -176,9 +268,18 @@ func makeHandler(method string, makeRequest makeEdulinkRequest, makeResult makeE
 		}
 	}
 
-	return fmt.Sprintf("/%s", method), &LoggingHandler{handler: h}
+	return schoolPath(session.Client().School().Code) + method, &LoggingHandler{handler: h}
+}
+
+// schoolPath is where the pages of a school live. School codes are chosen by
+// the schools, the fixed prefix keeps them clear of the server's own routes.
+func schoolPath(code string) string {
+	return fmt.Sprintf("/schools/%s/", code)
 }
 
 func (s *Server) Stop() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
 	return nil
 }
@@ -0,0 +1,74 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/cache/common"
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/edulink/edulinktest"
+	"github.com/eu-evops/edulink/pkg/worker"
+)
+
+// the site and report templates are read relative to the repository root
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestSchoolRoutes(t *testing.T) {
+	server := edulinktest.NewServer(nil)
+	defer server.Close()
+
+	c := cache.New(&common.CacheOptions{CacheType: common.Local})
+	if err := c.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+
+	options := server.ClientOptions()
+	options.Cache = c
+	client, err := edulink.NewClient(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := &worker.Account{
+		Name:    "parent",
+		Session: edulink.NewSession(&edulink.SessionOptions{Client: client, Username: "parent", Password: "password"}),
+	}
+
+	s := NewServer(&ServerOptions{Accounts: []*worker.Account{account}})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	tests := []struct {
+		path        string
+		wantStatus  int
+		wantReports string
+	}{
+		{path: "/", wantStatus: http.StatusOK, wantReports: "2"},
+		{path: "/schools/edulinktest/", wantStatus: http.StatusOK, wantReports: "2"},
+		{path: "/schools/edulinktest/EduLink.SchoolDetails", wantStatus: http.StatusOK},
+		{path: "/schools/edulinktest/EduLink.AchievementBehaviourLookups", wantStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			s.mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if got := recorder.Header().Get("X-EduLink-NumberOfReports"); got != test.wantReports {
+				t.Errorf("%q reports, want %q", got, test.wantReports)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/mailer"
+	"github.com/mailgun/mailgun-go/v4"
+	"github.com/mailgun/mailgun-go/v4/events"
+)
+
+const (
+	// webhookMaxAge rejects replayed webhooks with old timestamps
+	webhookMaxAge = 5 * time.Minute
+
+	webhookMaxBody = 1 << 20
+)
+
+// MailgunWebhook receives Mailgun event webhooks and records the delivered,
+// failed, complained and opened events in the delivery log
+type MailgunWebhook struct {
+	signingKey []byte
+	deliveries *mailer.DeliveryLog
+
+	// tokens already used within webhookMaxAge, a token is only accepted once
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+func NewMailgunWebhook(signingKey string, deliveries *mailer.DeliveryLog) *MailgunWebhook {
+	return &MailgunWebhook{
+		signingKey: []byte(signingKey),
+		deliveries: deliveries,
+		tokens:     map[string]time.Time{},
+	}
+}
+
+// verify checks the HMAC of the timestamp and token against the webhook
+// signing key, and rejects stale or reused tokens
+func (h *MailgunWebhook) verify(signature mailgun.Signature) bool {
+	timestamp, err := strconv.ParseInt(signature.TimeStamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > webhookMaxAge || age < -webhookMaxAge {
+		return false
+	}
+
+	expected, err := hex.DecodeString(signature.Signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, h.signingKey)
+	io.WriteString(mac, signature.TimeStamp)
+	io.WriteString(mac, signature.Token)
+	if !hmac.Equal(expected, mac.Sum(nil)) {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for token, seen := range h.tokens {
+		if time.Since(seen) > webhookMaxAge {
+			delete(h.tokens, token)
+		}
+	}
+
+	if _, used := h.tokens[signature.Token]; used {
+		return false
+	}
+	h.tokens[signature.Token] = time.Now()
+
+	return true
+}
+
+func (h *MailgunWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload mailgun.WebhookPayload
+	if err := json.NewDecoder(io.LimitReader(r.Body, webhookMaxBody)).Decode(&payload); err != nil {
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	if !h.verify(payload.Signature) {
+		log.Printf("Rejected Mailgun webhook with invalid signature from %s\n", r.RemoteAddr)
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := mailgun.ParseEvent(payload.EventData)
+	if err != nil {
+		// 406 tells Mailgun not to retry events we do not handle
+		log.Printf("Ignoring Mailgun webhook: %s\n", err)
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	var messageID string
+	deliveryEvent := mailer.DeliveryEvent{
+		Event: event.GetName(),
+		Time:  event.GetTimestamp(),
+	}
+
+	switch e := event.(type) {
+	case *events.Delivered:
+		messageID = e.Message.Headers.MessageID
+		deliveryEvent.Recipient = e.Recipient
+	case *events.Failed:
+		messageID = e.Message.Headers.MessageID
+		deliveryEvent.Recipient = e.Recipient
+		deliveryEvent.Severity = e.Severity
+		deliveryEvent.Reason = e.Reason
+		if e.DeliveryStatus.Message != "" {
+			deliveryEvent.Reason = e.DeliveryStatus.Message
+		}
+	case *events.Complained:
+		messageID = e.Message.Headers.MessageID
+		deliveryEvent.Recipient = e.Recipient
+	case *events.Opened:
+		messageID = e.Message.Headers.MessageID
+		deliveryEvent.Recipient = e.Recipient
+	default:
+		log.Printf("Ignoring Mailgun %s event\n", event.GetName())
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
+	if messageID == "" {
+		http.Error(w, "Missing message ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.deliveries.RecordEvent(r.Context(), messageID, deliveryEvent); err != nil {
+		// a 5xx makes Mailgun retry later
+		log.Printf("Could not record Mailgun %s event for %s: %s\n", deliveryEvent.Event, messageID, err)
+		http.Error(w, "Could not record event", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Mailgun %s event for %s (%s)\n", deliveryEvent.Event, messageID, deliveryEvent.Recipient)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,152 @@
+package web
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/cache/common"
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/mailer"
+)
+
+const testSigningKey = "key-signing"
+
+// webhookBody is a Mailgun delivered event for message, signed with key
+func webhookBody(key string, timestamp time.Time, token string, message string) string {
+	ts := fmt.Sprint(timestamp.Unix())
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(ts + token))
+
+	return fmt.Sprintf(`{
+		"signature": {"timestamp": %q, "token": %q, "signature": %q},
+		"event-data": {
+			"event": "delivered",
+			"timestamp": %d,
+			"recipient": "parent@example.com",
+			"message": {"headers": {"message-id": %q}}
+		}
+	}`, ts, token, hex.EncodeToString(mac.Sum(nil)), timestamp.Unix(), message)
+}
+
+func TestMailgunWebhook(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		method string
+		// bodies are posted in turn, the status of the last one is checked
+		bodies     []string
+		wantStatus int
+		wantEvents int
+	}{
+		{
+			name:       "valid",
+			bodies:     []string{webhookBody(testSigningKey, now, "token-1", "report@example.com")},
+			wantStatus: http.StatusOK,
+			wantEvents: 1,
+		},
+		{
+			name:       "clock skew within the window",
+			bodies:     []string{webhookBody(testSigningKey, now.Add(-4*time.Minute), "token-1", "report@example.com")},
+			wantStatus: http.StatusOK,
+			wantEvents: 1,
+		},
+		{
+			name:       "bad signature",
+			bodies:     []string{webhookBody("other-key", now, "token-1", "report@example.com")},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "stale timestamp",
+			bodies:     []string{webhookBody(testSigningKey, now.Add(-6*time.Minute), "token-1", "report@example.com")},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "timestamp in the future",
+			bodies:     []string{webhookBody(testSigningKey, now.Add(6*time.Minute), "token-1", "report@example.com")},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "replayed token",
+			bodies: []string{
+				webhookBody(testSigningKey, now, "token-1", "report@example.com"),
+				webhookBody(testSigningKey, now, "token-1", "report@example.com"),
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantEvents: 1,
+		},
+		{
+			name:       "malformed body",
+			bodies:     []string{`{"signature": `},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "malformed signature",
+			bodies:     []string{strings.Replace(webhookBody(testSigningKey, now, "token-1", "report@example.com"), `"signature": "`, `"signature": "zz`, 1)},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "no message ID",
+			bodies:     []string{webhookBody(testSigningKey, now, "token-1", "")},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "not a POST",
+			method:     http.MethodGet,
+			bodies:     []string{webhookBody(testSigningKey, now, "token-1", "report@example.com")},
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			c := cache.New(&common.CacheOptions{CacheType: common.Local})
+			if err := c.Initialise(); err != nil {
+				t.Fatal(err)
+			}
+			deliveries := mailer.NewDeliveryLog(&mailer.DeliveryLogOptions{Cache: c})
+
+			report := &edulink.SchoolReport{Child: edulink.Child{ID: "1001"}}
+			entry := mailer.NewOutboxEntry(report, &mailer.Message{MessageID: "<report@example.com>", To: []string{"parent@example.com"}})
+			if err := deliveries.RecordSent(ctx, "edulinktest", entry); err != nil {
+				t.Fatal(err)
+			}
+
+			method := test.method
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			webhook := NewMailgunWebhook(testSigningKey, deliveries)
+			var recorder *httptest.ResponseRecorder
+			for _, body := range test.bodies {
+				recorder = httptest.NewRecorder()
+				webhook.ServeHTTP(recorder, httptest.NewRequest(method, "/webhooks/mailgun", strings.NewReader(body)))
+			}
+
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status %d, want %d", recorder.Code, test.wantStatus)
+			}
+
+			statuses, err := deliveries.ForChild(ctx, "edulinktest", "1001")
+			if err != nil || len(statuses) != 1 {
+				t.Fatalf("deliveries %v, %v, want one", statuses, err)
+			}
+			if got := len(statuses[0].Events); got != test.wantEvents {
+				t.Errorf("recorded %d events, want %d", got, test.wantEvents)
+			}
+		})
+	}
+}
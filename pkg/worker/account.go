@@ -0,0 +1,156 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/edulink"
+)
+
+// AccountConfig is one parent account as configured in the accounts file
+type AccountConfig struct {
+	// Name namespaces the account's already seen state in the cache, defaults to the username
+	Name string `json:"name"`
+
+	// SchoolCode is the EduLink school code the account belongs to
+	SchoolCode string `json:"school_code"`
+
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// Recipients receive the reports of every child on the account
+	Recipients []string `json:"recipients"`
+
+	// Routes send the reports of some children to more recipients
+	Routes []Route `json:"routes"`
+}
+
+// LoadAccountConfigs reads a JSON list of accounts from path
+func LoadAccountConfigs(path string) ([]AccountConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var accountConfigs []AccountConfig
+	if err := json.Unmarshal(data, &accountConfigs); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for i := range accountConfigs {
+		a := &accountConfigs[i]
+		if a.Username == "" || a.Password == "" || a.SchoolCode == "" {
+			return nil, fmt.Errorf("account %d in %s needs a school_code, username and password", i+1, path)
+		}
+
+		if a.Name == "" {
+			a.Name = a.Username
+		}
+
+		for j := range a.Routes {
+			if err := a.Routes[j].validate(); err != nil {
+				return nil, fmt.Errorf("account %q in %s, route %d: %w", a.Name, path, j+1, err)
+			}
+		}
+
+		key := strings.ToLower(a.Name)
+		if names[key] {
+			return nil, fmt.Errorf("account name %q is used twice in %s", a.Name, path)
+		}
+		names[key] = true
+	}
+
+	return accountConfigs, nil
+}
+
+// Account is a parent account the worker reports on
+type Account struct {
+	// Name scopes the already seen state and outbox, see Cache. Leave it
+	// empty to use the school wide state.
+	Name string
+
+	// Session talks to EduLink for the account. When it is nil, Connect
+	// creates it with NewSession, and every run tries again until that
+	// succeeds, e.g. while the school cannot be resolved.
+	Session    *edulink.Session
+	NewSession func(ctx context.Context) (*edulink.Session, error)
+
+	// Recipients receive the reports of every child, Routes those of the
+	// children they match, see Deliveries
+	Recipients []string
+	Routes     []Route
+
+	// connecting serialises Connect, mu guards Session while it talks to
+	// EduLink
+	connecting sync.Mutex
+	mu         sync.Mutex
+}
+
+// Connected returns the account's session, nil until Connect succeeded
+func (a *Account) Connected() *edulink.Session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.Session
+}
+
+// Connect returns the account's session, creating it with NewSession when
+// there is none yet
+func (a *Account) Connect(ctx context.Context) (*edulink.Session, error) {
+	a.connecting.Lock()
+	defer a.connecting.Unlock()
+
+	if session := a.Connected(); session != nil {
+		return session, nil
+	}
+	if a.NewSession == nil {
+		return nil, errors.New("account has no session")
+	}
+
+	session, err := a.NewSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.Session = session
+	a.mu.Unlock()
+	return session, nil
+}
+
+// Cache scopes c to the account, so accounts sharing it keep their already
+// seen state and outbox apart. An account without a name uses c as it is.
+func (a *Account) Cache(c *cache.Cache) *cache.Cache {
+	if c == nil || a.Name == "" {
+		return c
+	}
+	return c.WithPrefix(fmt.Sprintf("account:%s", a.Name))
+}
+
+// LoadRoutes reads a JSON list of routes from path
+func LoadRoutes(path string) ([]Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []Route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for i := range routes {
+		if err := routes[i].validate(); err != nil {
+			return nil, fmt.Errorf("route %d in %s: %w", i+1, path, err)
+		}
+	}
+
+	return routes, nil
+}
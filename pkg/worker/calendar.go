@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// HolidayMode decides what happens to reports during school holidays
+type HolidayMode string
+
+const (
+	// HolidaySkip skips runs during holidays, items recorded in a holiday are
+	// stale by the time school starts and are marked seen without an email
+	HolidaySkip HolidayMode = "skip"
+
+	// HolidayDigest skips runs during holidays and merges everything from the
+	// holiday into one "back to school" email per child on the first run after
+	HolidayDigest HolidayMode = "digest"
+)
+
+const dateLayout = "2006-01-02"
+
+// Period is a range of whole days, both ends included
+type Period struct {
+	Name  string `json:"name"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	start time.Time
+	end   time.Time
+}
+
+func (p *Period) parse() error {
+	var err error
+	if p.start, err = time.Parse(dateLayout, p.Start); err != nil {
+		return fmt.Errorf("period %q start: %w", p.Name, err)
+	}
+	if p.end, err = time.Parse(dateLayout, p.End); err != nil {
+		return fmt.Errorf("period %q end: %w", p.Name, err)
+	}
+	if p.end.Before(p.start) {
+		return fmt.Errorf("period %q ends before it starts", p.Name)
+	}
+	return nil
+}
+
+// Contains reports whether the day of t, in its own location, is in the period
+func (p *Period) Contains(t time.Time) bool {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return !day.Before(p.start) && !day.After(p.end)
+}
+
+// Calendar holds the term dates of a school. Days outside every term, when
+// terms are given, and days in a holiday are holidays.
+type Calendar struct {
+	Terms    []Period `json:"terms"`
+	Holidays []Period `json:"holidays"`
+}
+
+// LoadCalendar reads term dates from an ICS file, or else from JSON like
+//
+//	{"terms": [{"name": "Autumn", "start": "2026-09-03", "end": "2026-10-23"}],
+//	 "holidays": [{"name": "Half term", "start": "2026-10-26", "end": "2026-10-30"}]}
+//
+// In an ICS file events whose summary mentions a holiday or half term are
+// holidays, all other events are terms.
+func LoadCalendar(path string) (*Calendar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	calendar := &Calendar{}
+	if strings.EqualFold(filepath.Ext(path), ".ics") {
+		calendar, err = parseICS(data)
+	} else {
+		err = json.Unmarshal(data, calendar)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for _, periods := range [][]Period{calendar.Terms, calendar.Holidays} {
+		for i := range periods {
+			if err := periods[i].parse(); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", path, err)
+			}
+		}
+	}
+
+	return calendar, nil
+}
+
+// parseICS reads the VEVENTs of an iCalendar file. Only the dates of DTSTART
+// and DTEND are used, DTEND is exclusive for all day events.
+func parseICS(data []byte) (*Calendar, error) {
+	calendar := &Calendar{}
+
+	// continuation lines start with a space or tab
+	unfolded := bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n "), nil)
+	unfolded = bytes.ReplaceAll(unfolded, []byte("\n\t"), nil)
+
+	var event map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(unfolded))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "BEGIN:VEVENT":
+			event = map[string]string{}
+		case line == "END:VEVENT":
+			if event == nil {
+				continue
+			}
+
+			period, err := icsPeriod(event)
+			if err != nil {
+				return nil, err
+			}
+
+			summary := strings.ToLower(period.Name)
+			if strings.Contains(summary, "holiday") || strings.Contains(summary, "half term") || strings.Contains(summary, "half-term") {
+				calendar.Holidays = append(calendar.Holidays, period)
+			} else {
+				calendar.Terms = append(calendar.Terms, period)
+			}
+			event = nil
+		case event != nil:
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			// drop parameters, e.g. DTSTART;VALUE=DATE
+			name, _, _ = strings.Cut(name, ";")
+			event[strings.ToUpper(name)] = value
+		}
+	}
+
+	return calendar, scanner.Err()
+}
+
+func icsPeriod(event map[string]string) (Period, error) {
+	period := Period{Name: strings.ReplaceAll(event["SUMMARY"], `\,`, ",")}
+
+	start, err := icsDate(event["DTSTART"])
+	if err != nil {
+		return period, fmt.Errorf("event %q DTSTART: %w", period.Name, err)
+	}
+
+	end := start
+	if value, ok := event["DTEND"]; ok {
+		if end, err = icsDate(value); err != nil {
+			return period, fmt.Errorf("event %q DTEND: %w", period.Name, err)
+		}
+
+		// all day events end the day after their last day
+		if len(value) == len("20060102") && end.After(start) {
+			end = end.AddDate(0, 0, -1)
+		}
+	}
+
+	period.Start = start.Format(dateLayout)
+	period.End = end.Format(dateLayout)
+	return period, nil
+}
+
+func icsDate(value string) (time.Time, error) {
+	if len(value) < len("20060102") {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+// InHoliday reports whether the day of t is a holiday
+func (c *Calendar) InHoliday(t time.Time) bool {
+	if c == nil {
+		return false
+	}
+
+	for i := range c.Holidays {
+		if c.Holidays[i].Contains(t) {
+			return true
+		}
+	}
+
+	if len(c.Terms) == 0 {
+		return false
+	}
+
+	for i := range c.Terms {
+		if c.Terms[i].Contains(t) {
+			return false
+		}
+	}
+	return true
+}
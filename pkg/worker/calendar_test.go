@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testICS = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Autumn term\r\n" +
+	"DTSTART;VALUE=DATE:20260903\r\n" +
+	"DTEND;VALUE=DATE:20261219\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:October half\r\n" +
+	"  term\r\n" +
+	"DTSTART;VALUE=DATE:20261026\r\n" +
+	"DTEND;VALUE=DATE:20261031\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Staff training\\, INSET\r\n" +
+	"DTSTART:20261102T090000Z\r\n" +
+	"DTEND:20261102T150000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Bank Holiday\r\n" +
+	"DTSTART;VALUE=DATE:20261225\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICS(t *testing.T) {
+	calendar, err := parseICS([]byte(testICS))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantTerms := []Period{
+		// all day DTEND is exclusive
+		{Name: "Autumn term", Start: "2026-09-03", End: "2026-12-18"},
+		// timed events cover the day they are on
+		{Name: "Staff training, INSET", Start: "2026-11-02", End: "2026-11-02"},
+	}
+	wantHolidays := []Period{
+		// folded over two lines
+		{Name: "October half term", Start: "2026-10-26", End: "2026-10-30"},
+		// no DTEND is a single day
+		{Name: "Bank Holiday", Start: "2026-12-25", End: "2026-12-25"},
+	}
+
+	if !reflect.DeepEqual(calendar.Terms, wantTerms) {
+		t.Errorf("terms %+v, want %+v", calendar.Terms, wantTerms)
+	}
+	if !reflect.DeepEqual(calendar.Holidays, wantHolidays) {
+		t.Errorf("holidays %+v, want %+v", calendar.Holidays, wantHolidays)
+	}
+}
+
+func TestParseICSInvalidDate(t *testing.T) {
+	ics := "BEGIN:VEVENT\nSUMMARY:Broken\nDTSTART:2026\nEND:VEVENT\n"
+	if _, err := parseICS([]byte(ics)); err == nil {
+		t.Error("parsed an event with an invalid DTSTART")
+	}
+}
+
+func TestCalendarInHoliday(t *testing.T) {
+	dir := t.TempDir()
+
+	icsPath := filepath.Join(dir, "terms.ics")
+	if err := os.WriteFile(icsPath, []byte(testICS), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonPath := filepath.Join(dir, "terms.json")
+	json := `{"terms": [{"name": "Autumn", "start": "2026-09-03", "end": "2026-12-18"}],
+		"holidays": [{"name": "Half term", "start": "2026-10-26", "end": "2026-10-30"}]}`
+	if err := os.WriteFile(jsonPath, []byte(json), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		day  string
+		want bool
+	}{
+		{"2026-09-02", true},
+		{"2026-09-03", false},
+		{"2026-10-23", false},
+		{"2026-10-26", true},
+		{"2026-10-30", true},
+		{"2026-11-02", false},
+		{"2026-12-18", false},
+		{"2026-12-19", true},
+	}
+
+	for _, path := range []string{icsPath, jsonPath} {
+		calendar, err := LoadCalendar(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, test := range tests {
+			t.Run(filepath.Ext(path)+" "+test.day, func(t *testing.T) {
+				day, _ := time.Parse(dateLayout, test.day)
+				// late in the evening, the day counts in the time's own location
+				day = time.Date(day.Year(), day.Month(), day.Day(), 23, 30, 0, 0, time.FixedZone("UTC-5", -5*60*60))
+
+				if got := calendar.InHoliday(day); got != test.want {
+					t.Errorf("InHoliday(%s) = %t, want %t", test.day, got, test.want)
+				}
+			})
+		}
+	}
+}
+
+func TestLoadCalendarInvalidPeriod(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "terms.json")
+	if err := os.WriteFile(path, []byte(`{"holidays": [{"name": "Backwards", "start": "2026-10-30", "end": "2026-10-26"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadCalendar(path); err == nil {
+		t.Error("loaded a period that ends before it starts")
+	}
+}
+
+func TestCalendarNil(t *testing.T) {
+	var calendar *Calendar
+	if calendar.InHoliday(time.Now()) {
+		t.Error("no calendar should have no holidays")
+	}
+}
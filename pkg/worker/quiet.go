@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHours is a daily window in which no email goes out. Reports produced
+// in the window are held in the outbox until it ends.
+type QuietHours struct {
+	// Start and End are minutes after midnight, the window wraps past
+	// midnight when End is before Start
+	Start int
+	End   int
+}
+
+// ParseQuietHours parses a window like "21:30-07:00"
+func ParseQuietHours(value string) (*QuietHours, error) {
+	startPart, endPart, ok := strings.Cut(strings.TrimSpace(value), "-")
+	if !ok {
+		return nil, fmt.Errorf("quiet hours %q should look like 21:30-07:00", value)
+	}
+
+	start, err := parseClock(startPart)
+	if err != nil {
+		return nil, fmt.Errorf("quiet hours %q: %w", value, err)
+	}
+
+	end, err := parseClock(endPart)
+	if err != nil {
+		return nil, fmt.Errorf("quiet hours %q: %w", value, err)
+	}
+
+	if start == end {
+		return nil, fmt.Errorf("quiet hours %q start and end at the same time", value)
+	}
+
+	return &QuietHours{Start: start, End: end}, nil
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains reports whether t, in its own location, falls in the window
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if q.Start < q.End {
+		return minute >= q.Start && minute < q.End
+	}
+	return minute >= q.Start || minute < q.End
+}
+
+// EndCron is a cron expression firing when the window ends
+func (q *QuietHours) EndCron() string {
+	return fmt.Sprintf("%d %d * * *", q.End%60, q.End/60)
+}
+
+func (q *QuietHours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", q.Start/60, q.Start%60, q.End/60, q.End%60)
+}
@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    *QuietHours
+		wantErr bool
+	}{
+		{value: "21:30-07:00", want: &QuietHours{Start: 21*60 + 30, End: 7 * 60}},
+		{value: " 09:00 - 17:15 ", want: &QuietHours{Start: 9 * 60, End: 17*60 + 15}},
+		{value: "00:00-23:59", want: &QuietHours{Start: 0, End: 23*60 + 59}},
+		{value: "21:30", wantErr: true},
+		{value: "21:30-25:00", wantErr: true},
+		{value: "9pm-7am", wantErr: true},
+		{value: "07:00-07:00", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := ParseQuietHours(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestQuietHoursContains(t *testing.T) {
+	tests := []struct {
+		window string
+		clock  string
+		want   bool
+	}{
+		// across midnight
+		{"21:30-07:00", "21:29", false},
+		{"21:30-07:00", "21:30", true},
+		{"21:30-07:00", "23:59", true},
+		{"21:30-07:00", "00:00", true},
+		{"21:30-07:00", "06:59", true},
+		{"21:30-07:00", "07:00", false},
+		{"21:30-07:00", "12:00", false},
+
+		// within a day
+		{"09:00-17:00", "08:59", false},
+		{"09:00-17:00", "09:00", true},
+		{"09:00-17:00", "16:59", true},
+		{"09:00-17:00", "17:00", false},
+		{"09:00-17:00", "00:00", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.window+" "+test.clock, func(t *testing.T) {
+			quietHours, err := ParseQuietHours(test.window)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clock, err := time.Parse("15:04", test.clock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Date(2026, 10, 14, clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+
+			if got := quietHours.Contains(now); got != test.want {
+				t.Errorf("Contains(%s) = %t, want %t", test.clock, got, test.want)
+			}
+		})
+	}
+}
+
+func TestQuietHoursContainsLocalTime(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	quietHours, _ := ParseQuietHours("21:30-07:00")
+
+	// 06:30 UTC is 07:30 in London summer time
+	summer := time.Date(2026, 7, 1, 6, 30, 0, 0, time.UTC)
+	if !quietHours.Contains(summer) {
+		t.Error("06:30 UTC should be quiet")
+	}
+	if quietHours.Contains(summer.In(london)) {
+		t.Error("07:30 in London should not be quiet")
+	}
+}
+
+func TestQuietHoursNil(t *testing.T) {
+	var quietHours *QuietHours
+	if quietHours.Contains(time.Now()) {
+		t.Error("no quiet hours should never be quiet")
+	}
+}
+
+func TestQuietHoursEndCron(t *testing.T) {
+	quietHours, _ := ParseQuietHours("21:30-07:05")
+	if got, want := quietHours.EndCron(), "5 7 * * *"; got != want {
+		t.Errorf("EndCron() = %q, want %q", got, want)
+	}
+}
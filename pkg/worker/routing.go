@@ -0,0 +1,218 @@
+package worker
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/mailer"
+)
+
+// Content limits what a route's recipients are sent
+type Content string
+
+const (
+	ContentAll          Content = "all"
+	ContentAchievements Content = "achievements"
+	ContentBehaviour    Content = "behaviour"
+)
+
+// Route sends the reports of the children it matches to its recipients. A
+// child matches when it matches every matcher that is set, a route without
+// matchers matches every child on the account.
+type Route struct {
+	// ChildIDs match EduLink learner IDs
+	ChildIDs []string `json:"child_ids"`
+
+	// Names match the forename or "forename surname", case insensitive,
+	// wildcards as in path.Match
+	Names []string `json:"names"`
+
+	// YearGroups match the year group ID or name, e.g. "Year 9"
+	YearGroups []string `json:"year_groups"`
+
+	To  []string `json:"to"`
+	CC  []string `json:"cc"`
+	BCC []string `json:"bcc"`
+
+	// Content defaults to ContentAll
+	Content Content `json:"content"`
+}
+
+func (r *Route) validate() error {
+	if len(r.To)+len(r.CC)+len(r.BCC) == 0 {
+		return fmt.Errorf("route needs at least one to, cc or bcc recipient")
+	}
+
+	switch r.Content {
+	case "", ContentAll, ContentAchievements, ContentBehaviour:
+	default:
+		return fmt.Errorf("unknown route content %q, expected all, achievements or behaviour", r.Content)
+	}
+
+	for _, name := range r.Names {
+		if _, err := path.Match(strings.ToLower(name), ""); err != nil {
+			return fmt.Errorf("route name %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Route) matches(report *edulink.SchoolReport) bool {
+	child := report.Child
+
+	if len(r.ChildIDs) > 0 && !slices.Contains(r.ChildIDs, child.ID) {
+		return false
+	}
+
+	if len(r.Names) > 0 {
+		forename := strings.ToLower(child.Forename)
+		fullName := strings.ToLower(strings.TrimSpace(child.Forename + " " + child.Surname))
+
+		matched := false
+		for _, name := range r.Names {
+			name = strings.ToLower(name)
+			if ok, _ := path.Match(name, forename); ok {
+				matched = true
+			} else if ok, _ := path.Match(name, fullName); ok {
+				matched = true
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if len(r.YearGroups) > 0 {
+		yearGroupName := ""
+		for _, yearGroup := range report.School.YearGroups {
+			if yearGroup.ID == child.YearGroupID {
+				yearGroupName = yearGroup.Name
+			}
+		}
+
+		matched := false
+		for _, yearGroup := range r.YearGroups {
+			if yearGroup == child.YearGroupID || (yearGroupName != "" && strings.EqualFold(yearGroup, yearGroupName)) {
+				matched = true
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *Route) content() Content {
+	if r.Content == "" {
+		return ContentAll
+	}
+	return r.Content
+}
+
+// Delivery is one email of a report, with the content its recipients get
+type Delivery struct {
+	Content    Content
+	Recipients mailer.Recipients
+}
+
+// Report returns a copy of the report cut down to the delivery's content
+func (d *Delivery) Report(report *edulink.SchoolReport) edulink.SchoolReport {
+	filtered := *report
+
+	switch d.Content {
+	case ContentAchievements:
+		filtered.Behaviour = nil
+	case ContentBehaviour:
+		filtered.Achievement = nil
+	}
+
+	return filtered
+}
+
+// Deliveries resolves who receives a report. Matching routes with the same
+// content share one email. An address only receives the report once: full
+// reports win over achievements only and behaviour only, To over CC over BCC.
+func (a *Account) Deliveries(report *edulink.SchoolReport) []Delivery {
+	routes := a.Routes
+	if len(a.Recipients) > 0 {
+		routes = append([]Route{{To: a.Recipients}}, routes...)
+	}
+
+	byContent := map[Content]*mailer.Recipients{}
+	for i := range routes {
+		route := &routes[i]
+		if !route.matches(report) {
+			continue
+		}
+
+		recipients, ok := byContent[route.content()]
+		if !ok {
+			recipients = &mailer.Recipients{}
+			byContent[route.content()] = recipients
+		}
+
+		recipients.To = append(recipients.To, route.To...)
+		recipients.CC = append(recipients.CC, route.CC...)
+		recipients.BCC = append(recipients.BCC, route.BCC...)
+	}
+
+	deliveries := []Delivery{}
+	seen := map[string]bool{}
+	for _, content := range []Content{ContentAll, ContentAchievements, ContentBehaviour} {
+		recipients, ok := byContent[content]
+		if !ok {
+			continue
+		}
+
+		delivery := Delivery{
+			Content: content,
+			Recipients: mailer.Recipients{
+				To:  uniqueAddresses(recipients.To, seen),
+				CC:  uniqueAddresses(recipients.CC, seen),
+				BCC: uniqueAddresses(recipients.BCC, seen),
+			},
+		}
+
+		if !delivery.Recipients.Empty() {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	return deliveries
+}
+
+// undeliveredItems returns a report of the items none of the deliveries
+// carry, e.g. the behaviour of a child only routed achievements
+func undeliveredItems(report *edulink.SchoolReport, deliveries []Delivery) edulink.SchoolReport {
+	undelivered := *report
+	for i := range deliveries {
+		carried := deliveries[i].Report(report)
+		undelivered = withoutItems(undelivered, &carried)
+	}
+	return undelivered
+}
+
+// uniqueAddresses drops blank addresses and those already in seen, and adds
+// the rest to seen
+func uniqueAddresses(addresses []string, seen map[string]bool) []string {
+	unique := []string{}
+	for _, address := range addresses {
+		address = strings.TrimSpace(address)
+		key := strings.ToLower(address)
+		if address == "" || seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		unique = append(unique, address)
+	}
+	return unique
+}
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/mailer"
+)
+
+func testReport() *edulink.SchoolReport {
+	report := &edulink.SchoolReport{
+		Child:       edulink.Child{ID: "1001", Forename: "Sam", Surname: "Parent", YearGroupID: "9"},
+		Achievement: []edulink.Achievement{{ID: "a1"}},
+		Behaviour:   []edulink.Behaviour{{ID: "b1"}},
+	}
+	report.School.YearGroups = append(report.School.YearGroups, struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}{ID: "9", Name: "Year 9"})
+	return report
+}
+
+func TestAccountDeliveries(t *testing.T) {
+	tests := []struct {
+		name       string
+		recipients []string
+		routes     []Route
+		want       []Delivery
+	}{
+		{
+			name: "no recipients",
+			want: []Delivery{},
+		},
+		{
+			name:       "account recipients",
+			recipients: []string{"parent@example.com"},
+			want: []Delivery{
+				{Content: ContentAll, Recipients: mailer.Recipients{To: []string{"parent@example.com"}, CC: []string{}, BCC: []string{}}},
+			},
+		},
+		{
+			name:       "same address in to, cc and bcc",
+			recipients: []string{"parent@example.com"},
+			routes: []Route{
+				{CC: []string{"Parent@Example.com", "tutor@example.com"}, BCC: []string{"parent@example.com", "tutor@example.com", "head@example.com"}},
+			},
+			want: []Delivery{
+				{Content: ContentAll, Recipients: mailer.Recipients{
+					To:  []string{"parent@example.com"},
+					CC:  []string{"tutor@example.com"},
+					BCC: []string{"head@example.com"},
+				}},
+			},
+		},
+		{
+			name:       "full report wins over restricted content",
+			recipients: []string{"parent@example.com"},
+			routes: []Route{
+				{To: []string{"parent@example.com", "coach@example.com"}, Content: ContentAchievements},
+				{To: []string{"coach@example.com"}, Content: ContentBehaviour},
+			},
+			want: []Delivery{
+				{Content: ContentAll, Recipients: mailer.Recipients{To: []string{"parent@example.com"}, CC: []string{}, BCC: []string{}}},
+				{Content: ContentAchievements, Recipients: mailer.Recipients{To: []string{"coach@example.com"}, CC: []string{}, BCC: []string{}}},
+			},
+		},
+		{
+			name: "routes share one email per content",
+			routes: []Route{
+				{ChildIDs: []string{"1001"}, To: []string{"one@example.com"}},
+				{Names: []string{"sam*"}, To: []string{"two@example.com", " "}},
+				{YearGroups: []string{"year 9"}, CC: []string{"three@example.com"}},
+			},
+			want: []Delivery{
+				{Content: ContentAll, Recipients: mailer.Recipients{
+					To:  []string{"one@example.com", "two@example.com"},
+					CC:  []string{"three@example.com"},
+					BCC: []string{},
+				}},
+			},
+		},
+		{
+			name: "routes for other children",
+			routes: []Route{
+				{ChildIDs: []string{"1002"}, To: []string{"one@example.com"}},
+				{Names: []string{"alex"}, To: []string{"two@example.com"}},
+				{YearGroups: []string{"7", "Year 8"}, To: []string{"three@example.com"}},
+				{ChildIDs: []string{"1001"}, Names: []string{"alex"}, To: []string{"four@example.com"}},
+			},
+			want: []Delivery{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			account := &Account{Recipients: test.recipients, Routes: test.routes}
+
+			got := account.Deliveries(testReport())
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUndeliveredItems(t *testing.T) {
+	tests := []struct {
+		name             string
+		contents         []Content
+		wantAchievements []string
+		wantBehaviours   []string
+	}{
+		{name: "no deliveries", wantAchievements: []string{"a1"}, wantBehaviours: []string{"b1"}},
+		{name: "full report", contents: []Content{ContentAll}, wantAchievements: []string{}, wantBehaviours: []string{}},
+		{name: "achievements only", contents: []Content{ContentAchievements}, wantAchievements: []string{}, wantBehaviours: []string{"b1"}},
+		{name: "behaviour only", contents: []Content{ContentBehaviour}, wantAchievements: []string{"a1"}, wantBehaviours: []string{}},
+		{name: "both restricted", contents: []Content{ContentAchievements, ContentBehaviour}, wantAchievements: []string{}, wantBehaviours: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deliveries := []Delivery{}
+			for _, content := range test.contents {
+				deliveries = append(deliveries, Delivery{Content: content})
+			}
+
+			undelivered := undeliveredItems(testReport(), deliveries)
+			if got := undelivered.AchievementIDs(); !reflect.DeepEqual(got, test.wantAchievements) {
+				t.Errorf("achievements %v, want %v", got, test.wantAchievements)
+			}
+			if got := undelivered.BehaviourIDs(); !reflect.DeepEqual(got, test.wantBehaviours) {
+				t.Errorf("behaviours %v, want %v", got, test.wantBehaviours)
+			}
+		})
+	}
+}
@@ -1,8 +1,13 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"slices"
+	"time"
 
 	"github.com/eu-evops/edulink/pkg/cache"
 	"github.com/eu-evops/edulink/pkg/edulink"
@@ -10,52 +15,447 @@ import (
 )
 
 type Worker struct {
-	cache           *cache.Cache
-	edulinkUsername string
-	edulinkPassword string
-	mailgunApiKey   string
+	accounts     []*Account
+	cache        *cache.Cache
+	mailer       mailer.MailerOptions
+	deliveries   *mailer.DeliveryLog
+	dryRun       bool
+	dryRunDir    string
+	dryRunFormat mailer.FileFormat
+
+	location    *time.Location
+	quietHours  *QuietHours
+	calendar    *Calendar
+	holidayMode HolidayMode
+
+	// now is the clock quiet hours and holidays are checked against
+	now func() time.Time
 }
 
 type WorkerOptions struct {
-	Cache           *cache.Cache
-	EdulinkUsername string
-	EdulinkPassword string
-	MailgunApiKey   string
+	Accounts []*Account
+	Cache    *cache.Cache
+	// Mailer configures the sender, subject and transport of the reports
+	Mailer *mailer.MailerOptions
+
+	// Deliveries records the sent reports, for the delivery status in the web UI
+	Deliveries *mailer.DeliveryLog
+
+	// DryRun writes every report as an .eml file into DryRunDir instead of
+	// sending it, without marking anything as seen
+	DryRun    bool
+	DryRunDir string
+
+	// DryRunFormat defaults to mailer.FileFormatEml
+	DryRunFormat mailer.FileFormat
+
+	// Location quiet hours and term dates are in, defaults to time.Local
+	Location *time.Location
+
+	// QuietHours holds reports in the outbox until the window ends, see Release
+	QuietHours *QuietHours
+
+	// Calendar skips runs during school holidays, HolidayMode decides what
+	// happens to the items recorded in them and defaults to HolidaySkip
+	Calendar    *Calendar
+	HolidayMode HolidayMode
 }
 
 func NewWorker(o *WorkerOptions) *Worker {
+	dryRunDir := o.DryRunDir
+	if dryRunDir == "" {
+		dryRunDir = DefaultDryRunDir
+	}
+
+	mailerOptions := mailer.MailerOptions{}
+	if o.Mailer != nil {
+		mailerOptions = *o.Mailer
+	}
+
+	location := o.Location
+	if location == nil {
+		location = time.Local
+	}
+
+	holidayMode := o.HolidayMode
+	if holidayMode == "" {
+		holidayMode = HolidaySkip
+	}
+
 	return &Worker{
-		cache:           o.Cache,
-		edulinkUsername: o.EdulinkUsername,
-		edulinkPassword: o.EdulinkPassword,
-		mailgunApiKey:   o.MailgunApiKey,
+		location:     location,
+		quietHours:   o.QuietHours,
+		calendar:     o.Calendar,
+		holidayMode:  holidayMode,
+		accounts:     o.Accounts,
+		cache:        o.Cache,
+		mailer:       mailerOptions,
+		deliveries:   o.Deliveries,
+		dryRun:       o.DryRun,
+		dryRunDir:    dryRunDir,
+		dryRunFormat: o.DryRunFormat,
+		now:          time.Now,
 	}
 }
 
+const DefaultDryRunDir = "dry-run"
+
+// workerLockName is the lease held while a worker runs, shared by all workers
+// using the same cache
+const workerLockName = "worker"
+
+// RunOptions changes what a run reports on
+type RunOptions struct {
+	// MaximumAge of the items reported on, defaults to edulink.Year
+	MaximumAge time.Duration
+
+	// ReportPrevious includes items already reported, e.g. for a weekly digest
+	ReportPrevious bool
+}
+
+// Start reports on every account once
 func (w *Worker) Start() error {
+	return w.Run(context.Background(), nil)
+}
 
-	if os.Getenv("SEND_EMAIL") != "true" {
-		fmt.Println("Not sending email because SEND_EMAIL is not set to true")
+// Run reports on every account in turn. Accounts are isolated from each
+// other, a failing account is logged and the remaining accounts still run.
+func (w *Worker) Run(ctx context.Context, options *RunOptions) error {
+	if options == nil {
+		options = &RunOptions{}
+	}
+	if options.MaximumAge == 0 {
+		options.MaximumAge = edulink.Year
+	}
+
+	if now := w.now().In(w.location); w.calendar.InHoliday(now) {
+		fmt.Printf("Not reporting, %s is in the school holidays\n", now.Format("Monday, Jan 02"))
 		return nil
 	}
 
-	mailer := mailer.NewMailer(&mailer.MailerOptions{
-		MailgunApiKey: w.mailgunApiKey,
-	})
+	mailer, err := w.newMailer()
+	if err != nil || mailer == nil {
+		return err
+	}
+
+	lock, err := w.lock(ctx)
+	if err != nil || lock == nil {
+		return err
+	}
+	defer lock.Unlock()
+	ctx = lock.Context()
+
+	errs := []error{}
+	for _, account := range w.accounts {
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Errorf("account %s: %w", accountLabel(account), ctx.Err()))
+			continue
+		}
+
+		if err := w.runAccount(ctx, mailer, account, options); err != nil {
+			log.Printf("Account %s failed: %s\n", accountLabel(account), err)
+			errs = append(errs, fmt.Errorf("account %s: %w", accountLabel(account), err))
+		}
+		w.save(account)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (w *Worker) runAccount(ctx context.Context, m *mailer.Mailer, account *Account, options *RunOptions) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	session, err := account.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to EduLink: %w", err)
+	}
 
 	reporter := edulink.NewReporter(&edulink.ReporterOptions{
-		Username: w.edulinkUsername,
-		Password: w.edulinkPassword,
-		Cache:    w.cache,
+		Session: session,
+		Cache:   account.Cache(w.cache),
 	})
+	outbox := w.outbox(m, account)
+	w.moveLegacyState(ctx, account, reporter, outbox)
 
-	schoolReports := reporter.Prepare(nil)
+	schoolReports, err := reporter.Prepare(ctx, &edulink.PrepareOptions{
+		MaximumAge:     options.MaximumAge,
+		ReportPrevious: options.ReportPrevious,
+	})
+	if err != nil {
+		return err
+	}
+
+	markSeen := func(behaviourIDs []string, achievementIDs []string) {
+		w.markSeen(ctx, reporter, behaviourIDs, achievementIDs)
+	}
 
 	for _, report := range *schoolReports {
-		if len(report.Achievement) > 0 || len(report.Behaviour) > 0 {
-			mailer.Send(&report, reporter.Generate(&report))
+		backToSchool := false
+		if w.calendar != nil {
+			holidayReport := w.holidayItems(&report)
+			if len(holidayReport.Achievement) > 0 || len(holidayReport.Behaviour) > 0 {
+				if w.holidayMode == HolidayDigest {
+					backToSchool = true
+				} else {
+					fmt.Printf("Dropping %d items recorded for %s in the holidays\n", len(holidayReport.Achievement)+len(holidayReport.Behaviour), report.Child.Forename)
+					markSeen(holidayReport.BehaviourIDs(), holidayReport.AchievementIDs())
+					report = withoutItems(report, &holidayReport)
+				}
+			}
+		}
+
+		if len(report.Achievement) == 0 && len(report.Behaviour) == 0 {
+			continue
+		}
+
+		deliveries := account.Deliveries(&report)
+		if undelivered := undeliveredItems(&report, deliveries); len(undelivered.Achievement) > 0 || len(undelivered.Behaviour) > 0 {
+			fmt.Printf("No recipients for %d items of %s, marking them as seen\n", len(undelivered.Achievement)+len(undelivered.Behaviour), report.Child.Forename)
+			markSeen(undelivered.BehaviourIDs(), undelivered.AchievementIDs())
+		}
+
+		for _, delivery := range deliveries {
+			deliveryReport := delivery.Report(&report)
+			if len(deliveryReport.Achievement) == 0 && len(deliveryReport.Behaviour) == 0 {
+				continue
+			}
+
+			message := m.Compose(&deliveryReport, reporter.GenerateEmail(&deliveryReport), reporter.GenerateText(&deliveryReport), delivery.Recipients)
+			if backToSchool {
+				message.Subject = "Back to school: " + message.Subject
+			}
+			entry := mailer.NewOutboxEntry(&deliveryReport, message)
+
+			err := outbox.Enqueue(ctx, entry)
+			if errors.Is(err, mailer.ErrAlreadySent) {
+				// sent by an earlier run that stopped before marking it seen
+				markSeen(entry.BehaviourIDs, entry.AchievementIDs)
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	// each run replaces what earlier runs held for the same child and
+	// recipients, so the window ends with one message each
+	if now := w.now().In(w.location); w.quietHours.Contains(now) {
+		pending, _ := outbox.Pending(ctx)
+		fmt.Printf("Quiet hours %s, holding %d messages for %s\n", w.quietHours, len(pending), accountLabel(account))
+		return nil
+	}
+
+	return w.flush(ctx, outbox, account, reporter)
+}
+
+// lock takes the worker lease, so workers sharing the cache do not report the
+// same items twice. It returns nil when another worker holds the lease.
+func (w *Worker) lock(ctx context.Context) (*cache.Lock, error) {
+	// a dry run sends nothing, it need not wait for anyone
+	lockCache := w.cache
+	if w.dryRun {
+		lockCache = nil
+	}
+
+	lock, err := lockCache.Lock(ctx, workerLockName, nil)
+	if errors.Is(err, cache.ErrLocked) {
+		fmt.Println("Another worker is running, skipping this run")
+		return nil, nil
+	}
+	return lock, err
+}
+
+// newMailer returns the mailer for a run, nil when email is not enabled
+func (w *Worker) newMailer() (*mailer.Mailer, error) {
+	mailerOptions := w.mailer
+	if w.dryRun {
+		fileTransport, err := mailer.NewFileTransport(&mailer.FileTransportOptions{
+			Dir:    w.dryRunDir,
+			Format: w.dryRunFormat,
+		})
+		if err != nil {
+			return nil, err
 		}
+
+		fmt.Println("Dry run, writing emails to", fileTransport.Path())
+		mailerOptions.Transport = fileTransport
+	} else if os.Getenv("SEND_EMAIL") != "true" {
+		fmt.Println("Not sending email because SEND_EMAIL is not set to true")
+		return nil, nil
+	}
+
+	return mailer.NewMailer(&mailerOptions), nil
+}
+
+func (w *Worker) outbox(m *mailer.Mailer, account *Account) *mailer.Outbox {
+	// a dry run keeps its outbox in memory and leaves everything unseen
+	outboxCache := w.cache
+	if w.dryRun {
+		outboxCache = nil
+	}
+
+	return mailer.NewOutbox(&mailer.OutboxOptions{
+		Cache:     account.Cache(outboxCache),
+		Transport: m.Transport(),
+	})
+}
+
+// moveLegacyState moves the already seen state and outbox the account kept in
+// the shared cache, before accounts had a cache prefix of their own. A dry run
+// leaves it where it is.
+func (w *Worker) moveLegacyState(ctx context.Context, account *Account, reporter *edulink.Reporter, outbox *mailer.Outbox) {
+	if w.cache == nil || w.dryRun {
+		return
+	}
+
+	if account.Name != "" {
+		if err := reporter.MoveAlreadySeen(ctx, w.cache, account.Name); err != nil {
+			log.Printf("Could not move the already seen state of %s: %s\n", accountLabel(account), err)
+		}
+	}
+
+	if err := outbox.MoveFrom(ctx, w.cache, legacyOutboxName(account)); err != nil {
+		log.Printf("Could not move the outbox of %s: %s\n", accountLabel(account), err)
+	}
+}
+
+func (w *Worker) markSeen(ctx context.Context, reporter *edulink.Reporter, behaviourIDs []string, achievementIDs []string) {
+	if w.dryRun {
+		return
+	}
+
+	// what went out is marked even while the run is being cancelled, it
+	// would be sent again otherwise
+	if err := reporter.MarkSeen(context.WithoutCancel(ctx), behaviourIDs, achievementIDs); err != nil {
+		log.Printf("Could not mark items as seen: %s\n", err)
+	}
+}
+
+// flush sends what is in the account's outbox. Items are only marked seen
+// once a message carrying them went out, the rest stays in the outbox for the
+// next run.
+func (w *Worker) flush(ctx context.Context, outbox *mailer.Outbox, account *Account, reporter *edulink.Reporter) error {
+	return outbox.Flush(ctx, func(entry *mailer.OutboxEntry) {
+		w.markSeen(ctx, reporter, entry.BehaviourIDs, entry.AchievementIDs)
+
+		if w.deliveries != nil && !w.dryRun {
+			school := account.Session.Client().School().Code
+			if err := w.deliveries.RecordSent(ctx, school, entry); err != nil {
+				log.Printf("Could not record delivery of %s: %s\n", entry.Message.MessageID, err)
+			}
+		}
+	})
+}
+
+// Release sends the reports held in every account's outbox, e.g. when the
+// quiet hours end
+func (w *Worker) Release(ctx context.Context) error {
+	if now := w.now().In(w.location); w.quietHours.Contains(now) {
+		fmt.Printf("Still in quiet hours %s, not releasing held messages\n", w.quietHours)
+		return nil
+	}
+
+	m, err := w.newMailer()
+	if err != nil || m == nil {
+		return err
+	}
+
+	lock, err := w.lock(ctx)
+	if err != nil || lock == nil {
+		return err
 	}
+	defer lock.Unlock()
+	ctx = lock.Context()
+
+	errs := []error{}
+	for _, account := range w.accounts {
+		session, err := account.Connect(ctx)
+		if err != nil {
+			log.Printf("Releasing messages of %s failed: %s\n", accountLabel(account), err)
+			errs = append(errs, fmt.Errorf("account %s: connecting to EduLink: %w", accountLabel(account), err))
+			continue
+		}
+
+		reporter := edulink.NewReporter(&edulink.ReporterOptions{
+			Session: session,
+			Cache:   account.Cache(w.cache),
+		})
+		outbox := w.outbox(m, account)
+		w.moveLegacyState(ctx, account, reporter, outbox)
+
+		if err := w.flush(ctx, outbox, account, reporter); err != nil {
+			log.Printf("Releasing messages of %s failed: %s\n", accountLabel(account), err)
+			errs = append(errs, fmt.Errorf("account %s: %w", accountLabel(account), err))
+		}
+		w.save(account)
+	}
+
+	return errors.Join(errs...)
+}
 
-	return nil
+// save persists what was sent and seen for the account straight away, the
+// Local cache would otherwise only write its snapshot on exit
+func (w *Worker) save(account *Account) {
+	if err := w.cache.Save(); err != nil {
+		log.Printf("Could not save the state of %s: %s\n", accountLabel(account), err)
+	}
+}
+
+// holidayItems returns a report of the items recorded during school holidays
+func (w *Worker) holidayItems(report *edulink.SchoolReport) edulink.SchoolReport {
+	holiday := edulink.SchoolReport{Child: report.Child}
+
+	for _, achievement := range report.Achievement {
+		if w.calendar.InHoliday(time.Time(achievement.Date)) {
+			holiday.Achievement = append(holiday.Achievement, achievement)
+		}
+	}
+
+	for _, behaviour := range report.Behaviour {
+		if w.calendar.InHoliday(time.Time(behaviour.Date)) {
+			holiday.Behaviour = append(holiday.Behaviour, behaviour)
+		}
+	}
+
+	return holiday
+}
+
+// withoutItems returns a copy of the report without the items of other
+func withoutItems(report edulink.SchoolReport, other *edulink.SchoolReport) edulink.SchoolReport {
+	achievementIDs := other.AchievementIDs()
+	behaviourIDs := other.BehaviourIDs()
+
+	report.Achievement = slices.DeleteFunc(slices.Clone(report.Achievement), func(a edulink.Achievement) bool {
+		return slices.Contains(achievementIDs, a.ID)
+	})
+	report.Behaviour = slices.DeleteFunc(slices.Clone(report.Behaviour), func(b edulink.Behaviour) bool {
+		return slices.Contains(behaviourIDs, b.ID)
+	})
+
+	return report
+}
+
+// legacyOutboxName is the name the account's outbox was kept under before
+// accounts had a cache prefix of their own
+func legacyOutboxName(account *Account) string {
+	name := account.Session.Client().School().Code
+	if account.Name != "" {
+		name = fmt.Sprintf("%s:%s", name, account.Name)
+	}
+	return name
+}
+
+func accountLabel(account *Account) string {
+	if account.Name != "" {
+		return account.Name
+	}
+
+	session := account.Connected()
+	if session == nil {
+		return "the unnamed account"
+	}
+	return session.Username()
 }
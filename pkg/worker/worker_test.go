@@ -0,0 +1,264 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eu-evops/edulink/pkg/cache"
+	"github.com/eu-evops/edulink/pkg/cache/common"
+	"github.com/eu-evops/edulink/pkg/edulink"
+	"github.com/eu-evops/edulink/pkg/edulink/edulinktest"
+	"github.com/eu-evops/edulink/pkg/mailer"
+)
+
+// the report templates are read relative to the repository root
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// recordingTransport keeps the messages it is given
+type recordingTransport struct {
+	mu   sync.Mutex
+	sent []*mailer.Message
+}
+
+func (t *recordingTransport) Send(ctx context.Context, message *mailer.Message) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sent = append(t.sent, message)
+	return message.MessageID, nil
+}
+
+func newTestCache(t *testing.T) *cache.Cache {
+	t.Helper()
+
+	c := cache.New(&common.CacheOptions{CacheType: common.Local})
+	if err := c.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newTestAccount(t *testing.T, server *edulinktest.Server, fixtures *edulinktest.Fixtures, recipients ...string) *Account {
+	t.Helper()
+
+	client, err := edulink.NewClient(server.ClientOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Account{
+		Name: fixtures.Accounts[0].Username,
+		Session: edulink.NewSession(&edulink.SessionOptions{
+			Client:   client,
+			Username: fixtures.Accounts[0].Username,
+			Password: fixtures.Accounts[0].Password,
+		}),
+		Recipients: recipients,
+	}
+}
+
+func TestQuietHoursHoldOneMessagePerChild(t *testing.T) {
+	t.Setenv("SEND_EMAIL", "true")
+
+	fixtures := edulinktest.DefaultFixtures()
+	server := edulinktest.NewServer(fixtures)
+	defer server.Close()
+
+	quietHours, err := ParseQuietHours("21:00-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &recordingTransport{}
+	w := NewWorker(&WorkerOptions{
+		Accounts:   []*Account{newTestAccount(t, server, fixtures, "parent@example.com")},
+		Cache:      newTestCache(t),
+		Mailer:     &mailer.MailerOptions{Transport: transport},
+		Location:   time.UTC,
+		QuietHours: quietHours,
+	})
+
+	night := time.Date(2026, 10, 14, 22, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return night }
+	if err := w.Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// an achievement recorded while the first run's messages are held
+	achievement := fixtures.Achievement["1001"][0]
+	achievement.ID = "a9"
+	fixtures.Achievement["1001"] = append(fixtures.Achievement["1001"], achievement)
+
+	w.now = func() time.Time { return night.Add(time.Hour) }
+	if err := w.Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(transport.sent) != 0 {
+		t.Fatalf("sent %d messages during quiet hours", len(transport.sent))
+	}
+
+	w.now = func() time.Time { return night.Add(9 * time.Hour) }
+	if err := w.Release(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// one message for each of the two children, the second run's covering a9
+	if len(transport.sent) != 2 {
+		t.Fatalf("released %d messages, want 2", len(transport.sent))
+	}
+
+	seen, err := w.accounts[0].Cache(w.cache).SetMembers(context.Background(), "set:alreadySeenAchievementIDs:edulinktest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(seen)
+	if want := []string{"a1", "a2", "a3", "a9"}; !slices.Equal(seen, want) {
+		t.Errorf("seen achievements %v, want %v", seen, want)
+	}
+}
+
+// an account whose school cannot be resolved yet is tried again every run
+func TestRunConnectsAccountsLater(t *testing.T) {
+	t.Setenv("SEND_EMAIL", "true")
+
+	fixtures := edulinktest.DefaultFixtures()
+	server := edulinktest.NewServer(fixtures)
+	defer server.Close()
+
+	connected := newTestAccount(t, server, fixtures, "parent@example.com")
+	failures := 1
+	account := &Account{
+		Name: "later",
+		NewSession: func(ctx context.Context) (*edulink.Session, error) {
+			if failures > 0 {
+				failures--
+				return nil, errors.New("school not found")
+			}
+			return connected.Session, nil
+		},
+		Recipients: []string{"later@example.com"},
+	}
+
+	transport := &recordingTransport{}
+	w := NewWorker(&WorkerOptions{
+		Accounts: []*Account{account},
+		Cache:    newTestCache(t),
+		Mailer:   &mailer.MailerOptions{Transport: transport},
+		Location: time.UTC,
+	})
+
+	if err := w.Run(context.Background(), nil); err == nil {
+		t.Fatal("first run connected")
+	}
+	if account.Connected() != nil || len(transport.sent) != 0 {
+		t.Fatalf("first run connected, sent %d messages", len(transport.sent))
+	}
+
+	if err := w.Run(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if account.Connected() == nil {
+		t.Error("second run did not connect")
+	}
+	if len(transport.sent) != 2 {
+		t.Errorf("second run sent %d messages, want 2", len(transport.sent))
+	}
+}
+
+// state kept in the shared cache before accounts had a prefix of their own
+func TestRunMovesLegacyState(t *testing.T) {
+	t.Setenv("SEND_EMAIL", "true")
+	ctx := context.Background()
+
+	fixtures := edulinktest.DefaultFixtures()
+	server := edulinktest.NewServer(fixtures)
+	defer server.Close()
+
+	c := newTestCache(t)
+	if err := c.SetAdd(ctx, "set:alreadySeenAchievementIDs:edulinktest:parent", 0, "a1", "a2", "a3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(&common.Item{Ctx: ctx, Key: "alreadySeenBehaviourIDs:edulinktest:parent", Value: []string{"b1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	account := newTestAccount(t, server, fixtures)
+	w := NewWorker(&WorkerOptions{
+		Accounts: []*Account{account},
+		Cache:    c,
+		Mailer:   &mailer.MailerOptions{Transport: &recordingTransport{}},
+		Location: time.UTC,
+	})
+	if err := w.Run(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	seen, err := account.Cache(c).SetMembers(ctx, "set:alreadySeenAchievementIDs:edulinktest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(seen)
+	if want := []string{"a1", "a2", "a3"}; !slices.Equal(seen, want) {
+		t.Errorf("seen achievements %v, want %v", seen, want)
+	}
+
+	legacy, err := c.SetMembers(ctx, "set:alreadySeenAchievementIDs:edulinktest:parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(legacy) != 0 {
+		t.Errorf("legacy seen achievements %v were not removed", legacy)
+	}
+}
+
+// a crash after the run keeps what it sent and saw
+func TestRunSavesLocalSnapshot(t *testing.T) {
+	t.Setenv("SEND_EMAIL", "true")
+	ctx := context.Background()
+
+	fixtures := edulinktest.DefaultFixtures()
+	server := edulinktest.NewServer(fixtures)
+	defer server.Close()
+
+	options := &common.CacheOptions{CacheType: common.Local, LocalSnapshotPath: filepath.Join(t.TempDir(), "cache.json")}
+	c := cache.New(options)
+	if err := c.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+
+	account := newTestAccount(t, server, fixtures, "parent@example.com")
+	w := NewWorker(&WorkerOptions{
+		Accounts: []*Account{account},
+		Cache:    c,
+		Mailer:   &mailer.MailerOptions{Transport: &recordingTransport{}},
+		Location: time.UTC,
+	})
+	if err := w.Run(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// loaded without closing c
+	loaded := cache.New(options)
+	if err := loaded.Initialise(); err != nil {
+		t.Fatal(err)
+	}
+	seen, err := account.Cache(loaded).SetMembers(ctx, "set:alreadySeenAchievementIDs:edulinktest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a1", "a2", "a3"}; !slices.Equal(seen, want) {
+		t.Errorf("saved seen achievements %v, want %v", seen, want)
+	}
+}